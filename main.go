@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"github.com/gmauleon/alertmanager-zabbix-provisioner/provisioner"
 	log "github.com/sirupsen/logrus"
@@ -15,14 +16,83 @@ func main() {
 	log.SetFormatter(&log.TextFormatter{DisableColors: true})
 
 	configFileName := flag.String("config", "./config.yaml", "path to the configuration file")
+	once := flag.Bool("once", false, "run a single provisioning cycle and exit")
+	dryRun := flag.Bool("dry-run", false, "print the changes a provisioning cycle would make and exit, without touching Zabbix")
+	planFormat := flag.String("plan-format", "text", "format of the dry-run output, text or json")
+	drift := flag.Bool("drift", false, "print a JSON report of the managed items and triggers changed by hand in Zabbix, and of the pending rule changes, and exit")
+	listenAddress := flag.String("listen-address", "", "address serving /healthz, /readyz and /metrics, overrides listenAddress from the configuration")
+	allowMassDeletion := flag.Bool("allow-mass-deletion", false, "disable the deletion guard, for intentional bulk removals")
 	flag.Parse()
 
+	// Keep stdout for the plan or the drift report
+	if *dryRun || *drift {
+		log.SetOutput(os.Stderr)
+	}
+
 	cfg, err := provisioner.ConfigFromFile(*configFileName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Debug(cfg)
+	if len(*listenAddress) != 0 {
+		cfg.ListenAddress = *listenAddress
+	}
+
+	log.Debugf("%+v", cfg.Redacted())
+
+	p, err := provisioner.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.AllowMassDeletion = *allowMassDeletion
+
+	if *dryRun {
+		plan, err := p.Plan()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		switch *planFormat {
+		case "json":
+			err = plan.WriteJSON(os.Stdout)
+		case "text":
+			err = plan.WriteText(os.Stdout)
+		default:
+			log.Fatalf("unknown plan format: %s", *planFormat)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *drift {
+		report, err := p.Drift()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *once {
+		err = p.Reconcile()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(cfg.ListenAddress) != 0 {
+		p.StartServer()
+	}
 
-	provisioner.New(cfg).Start()
+	p.Start()
 }
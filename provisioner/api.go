@@ -0,0 +1,421 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gmauleon/zabbix-client"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error code of the Zabbix API for unknown or missing parameters
+const ZabbixInvalidParams = -32602
+
+// Create a client for the Zabbix API at url, sending BearerToken in an Authorization header when set
+func (p *Provisioner) NewZabbixAPI(url string, transport http.RoundTripper) *zabbix.API {
+
+	api := zabbix.NewAPI(url)
+	api.SetClient(&http.Client{
+		Transport: &bearerRoundTripper{provisioner: p, next: transport},
+	})
+	return api
+}
+
+type bearerRoundTripper struct {
+	provisioner *Provisioner
+	next        http.RoundTripper
+}
+
+func (rt *bearerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	token := rt.provisioner.BearerToken
+	if len(token) == 0 {
+		return rt.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return rt.next.RoundTrip(req)
+}
+
+// Login with the user and password of the configuration, returning the session
+// Zabbix 5.4 renamed the user parameter to username and 6.4 removed user
+func (p *Provisioner) UserLogin() (string, error) {
+
+	login := func(params zabbix.Params) (response zabbix.Response, err error) {
+		err = instrument("user.login", func() (err error) {
+			response, err = p.Api.CallWithError("user.login", params)
+			return
+		})()
+		return
+	}
+
+	response, err := login(zabbix.Params{"username": p.Config.ZabbixApiUser, "password": p.Config.ZabbixApiPassword})
+	if e, ok := err.(*zabbix.Error); ok && e.Code == ZabbixInvalidParams {
+		response, err = login(zabbix.Params{"user": p.Config.ZabbixApiUser, "password": p.Config.ZabbixApiPassword})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	auth, ok := response.Result.(string)
+	if !ok {
+		return "", fmt.Errorf("user.login: unexpected result %v", response.Result)
+	}
+
+	return auth, nil
+}
+
+// Run a Zabbix API call, login again and retry it once if the session expired
+func (p *Provisioner) Call(method string, call func() error) error {
+
+	call = instrument(method, call)
+
+	err := call()
+	if err == nil || !IsSessionError(err) {
+		return err
+	}
+
+	// An API token can't be renewed
+	if len(p.Config.ZabbixApiToken) != 0 {
+		return err
+	}
+
+	log.Warnf("Zabbix session expired while calling %s, login again", method)
+	p.LoggedIn = false
+	// Sessions are terminated by Zabbix restarts, and so upgrades
+	p.ForgetVersion()
+	if err := p.Login(); err != nil {
+		return err
+	}
+
+	return call()
+}
+
+// Count the calls and errors of a Zabbix API method
+func instrument(method string, call func() error) func() error {
+	return func() error {
+		APICalls.WithLabelValues(method).Inc()
+		err := call()
+		if err != nil {
+			APIErrors.WithLabelValues(method).Inc()
+		}
+		return err
+	}
+}
+
+// Check if an error comes from an expired or revoked Zabbix session
+func IsSessionError(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "session terminated") ||
+		strings.Contains(message, "not authorised") ||
+		strings.Contains(message, "not authorized")
+}
+
+// Call a Zabbix API method the client has no helper for, decoding its result into result if not nil
+func (p *Provisioner) CallRaw(method string, params interface{}, result interface{}) error {
+
+	var response zabbix.Response
+	err := p.Call(method, func() (err error) {
+		response, err = p.Api.CallWithError(method, params)
+		return
+	})
+	if err != nil || result == nil {
+		return err
+	}
+
+	data, err := json.Marshal(response.Result)
+	if err != nil {
+		return fmt.Errorf("%s: %s", method, err)
+	}
+
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return fmt.Errorf("%s: can't decode result: %s", method, err)
+	}
+
+	return nil
+}
+
+func (p *Provisioner) HostGroupsGet(params zabbix.Params) (hostGroups zabbix.HostGroups, err error) {
+	err = p.Call("hostgroup.get", func() (err error) {
+		hostGroups, err = p.Api.HostGroupsGet(params)
+		return
+	})
+	return
+}
+
+func (p *Provisioner) HostGroupsCreate(hostGroups zabbix.HostGroups) error {
+	return p.Call("hostgroup.create", func() error {
+		return p.Api.HostGroupsCreate(hostGroups)
+	})
+}
+
+func (p *Provisioner) HostGroupsDelete(hostGroups zabbix.HostGroups) error {
+	return p.Call("hostgroup.delete", func() error {
+		return p.Api.HostGroupsDelete(hostGroups)
+	})
+}
+
+func (p *Provisioner) HostsGet(params zabbix.Params) (hosts zabbix.Hosts, err error) {
+	err = p.Call("host.get", func() (err error) {
+		hosts, err = p.Api.HostsGet(params)
+		return
+	})
+	return
+}
+
+func (p *Provisioner) HostsCreate(hosts zabbix.Hosts) error {
+	return p.Call("host.create", func() error {
+		return p.Api.HostsCreate(hosts)
+	})
+}
+
+func (p *Provisioner) HostsUpdate(hosts zabbix.Hosts) error {
+	return p.Call("host.update", func() error {
+		return p.Api.HostsUpdate(hosts)
+	})
+}
+
+func (p *Provisioner) HostsDelete(hosts zabbix.Hosts) error {
+	return p.Call("host.delete", func() error {
+		return p.Api.HostsDelete(hosts)
+	})
+}
+
+func (p *Provisioner) ApplicationsGet(params zabbix.Params) (applications zabbix.Applications, err error) {
+	err = p.Call("application.get", func() (err error) {
+		applications, err = p.Api.ApplicationsGet(params)
+		return
+	})
+	return
+}
+
+func (p *Provisioner) ApplicationsCreate(applications zabbix.Applications) error {
+	return p.Call("application.create", func() error {
+		return p.Api.ApplicationsCreate(applications)
+	})
+}
+
+func (p *Provisioner) ApplicationsDelete(applications zabbix.Applications) error {
+	return p.Call("application.delete", func() error {
+		return p.Api.ApplicationsDelete(applications)
+	})
+}
+
+func (p *Provisioner) ItemsGet(params zabbix.Params) (items zabbix.Items, err error) {
+	err = p.Call("item.get", func() (err error) {
+		items, err = p.Api.ItemsGet(params)
+		return
+	})
+	return
+}
+
+// Create the items of a host with their tags, filling their ids
+func (p *Provisioner) ItemsCreate(host *CustomHost, items zabbix.Items) error {
+
+	var result struct {
+		ItemIds []string `json:"itemids"`
+	}
+
+	err := p.CallRaw("item.create", p.TaggedItems(host, items), &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.ItemIds) != len(items) {
+		return fmt.Errorf("item.create returned %d ids for %d items", len(result.ItemIds), len(items))
+	}
+
+	for i, itemId := range result.ItemIds {
+		items[i].ItemId = itemId
+	}
+
+	return nil
+}
+
+func (p *Provisioner) ItemsUpdate(host *CustomHost, items zabbix.Items) error {
+	return p.CallRaw("item.update", p.TaggedItems(host, items), nil)
+}
+
+func (p *Provisioner) ItemsDelete(items zabbix.Items) error {
+	return p.Call("item.delete", func() error {
+		return p.Api.ItemsDelete(items)
+	})
+}
+
+func (p *Provisioner) TriggersGet(params zabbix.Params) (triggers zabbix.Triggers, err error) {
+	err = p.Call("trigger.get", func() (err error) {
+		triggers, err = p.Api.TriggersGet(params)
+		return
+	})
+	return
+}
+
+// Create the triggers of a host with their tags, filling their ids
+func (p *Provisioner) TriggersCreate(host *CustomHost, triggers zabbix.Triggers) error {
+
+	var result struct {
+		TriggerIds []string `json:"triggerids"`
+	}
+
+	err := p.CallRaw("trigger.create", p.TaggedTriggers(host, triggers), &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.TriggerIds) != len(triggers) {
+		return fmt.Errorf("trigger.create returned %d ids for %d triggers", len(result.TriggerIds), len(triggers))
+	}
+
+	for i, triggerId := range result.TriggerIds {
+		triggers[i].TriggerId = triggerId
+	}
+
+	return nil
+}
+
+func (p *Provisioner) TriggersUpdate(host *CustomHost, triggers zabbix.Triggers) error {
+	return p.CallRaw("trigger.update", p.TaggedTriggers(host, triggers), nil)
+}
+
+func (p *Provisioner) TriggersDelete(triggers zabbix.Triggers) error {
+	return p.Call("trigger.delete", func() error {
+		return p.Api.TriggersDelete(triggers)
+	})
+}
+
+func (p *Provisioner) TemplatesGet(params zabbix.Params) (templates Templates, err error) {
+	err = p.CallRaw("template.get", params, &templates)
+	return
+}
+
+func (p *Provisioner) TemplatesCreate(templates Templates) error {
+
+	var result struct {
+		TemplateIds []string `json:"templateids"`
+	}
+
+	err := p.CallRaw("template.create", templates, &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.TemplateIds) != len(templates) {
+		return fmt.Errorf("template.create returned %d ids for %d templates", len(result.TemplateIds), len(templates))
+	}
+
+	for i, templateId := range result.TemplateIds {
+		templates[i].TemplateId = templateId
+	}
+
+	return nil
+}
+
+func (p *Provisioner) TemplateGroupsGet(params zabbix.Params) (templateGroups zabbix.HostGroups, err error) {
+	err = p.CallRaw("templategroup.get", params, &templateGroups)
+	return
+}
+
+func (p *Provisioner) TemplateGroupsCreate(templateGroups zabbix.HostGroups) error {
+
+	var result struct {
+		GroupIds []string `json:"groupids"`
+	}
+
+	err := p.CallRaw("templategroup.create", templateGroups, &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.GroupIds) != len(templateGroups) {
+		return fmt.Errorf("templategroup.create returned %d ids for %d template groups", len(result.GroupIds), len(templateGroups))
+	}
+
+	for i, groupId := range result.GroupIds {
+		templateGroups[i].GroupId = groupId
+	}
+
+	return nil
+}
+
+func (p *Provisioner) TemplatesUpdate(templates Templates) error {
+	return p.CallRaw("template.update", templates, nil)
+}
+
+// Count the items, triggers and discovery rules of a template
+func (p *Provisioner) TemplateObjectsCount(templateId string) (int, error) {
+
+	total := 0
+	for _, method := range []string{"item.get", "trigger.get", "discoveryrule.get"} {
+		var count string
+		err := p.CallRaw(method, zabbix.Params{
+			"templateids": templateId,
+			"countOutput": true,
+		}, &count)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("%s: unexpected count %q", method, count)
+		}
+		total += n
+	}
+
+	return total, nil
+}
+
+// Count the templates of a host group, before Zabbix 6.2
+func (p *Provisioner) TemplatesCount(groupId string) (int, error) {
+
+	var count string
+	err := p.CallRaw("template.get", zabbix.Params{
+		"groupids":    groupId,
+		"countOutput": true,
+	}, &count)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, fmt.Errorf("template.get: unexpected count %q", count)
+	}
+
+	return n, nil
+}
+
+// Get the hosts with the templates linked to them
+func (p *Provisioner) HostsTemplatesGet(hostIds []string) (hosts HostsTemplates, err error) {
+	err = p.CallRaw("host.get", zabbix.Params{
+		"output":                []string{"hostid", "host"},
+		"hostids":               hostIds,
+		"selectParentTemplates": []string{"templateid", "host", "description"},
+	}, &hosts)
+	return
+}
+
+func (p *Provisioner) HostTemplatesLink(hostId string, templateIds []string) error {
+
+	templates := make([]map[string]string, 0, len(templateIds))
+	for _, templateId := range templateIds {
+		templates = append(templates, map[string]string{"templateid": templateId})
+	}
+
+	return p.CallRaw("host.massadd", zabbix.Params{
+		"hosts":     []map[string]string{{"hostid": hostId}},
+		"templates": templates,
+	}, nil)
+}
+
+// Unlink templates from a host, removing the items and triggers they brought
+func (p *Provisioner) HostTemplatesUnlink(hostId string, templateIds []string) error {
+	return p.CallRaw("host.massremove", zabbix.Params{
+		"hostids":           []string{hostId},
+		"templateids_clear": templateIds,
+	}, nil)
+}
@@ -0,0 +1,493 @@
+package provisioner
+
+import (
+	"fmt"
+	"github.com/gmauleon/zabbix-client"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Discovery rules are keyed like the item of the rule with that suffix
+const DiscoveryKeySuffix = ".discovery"
+
+// Characters not allowed in low-level discovery macro names
+var macroInvalidCharacters = regexp.MustCompile(`[^A-Z0-9_.]`)
+
+// One discovered item and trigger per alert instance instead of a single item and trigger per rule
+// Instances are told apart by the values of Labels, sent by the webhook to the trapper discovery rule
+type DiscoveryConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Labels  []string `yaml:"labels"`
+	// How long discovered items are kept once an instance is not discovered anymore, Zabbix default when empty
+	Lifetime string `yaml:"lifetime"`
+}
+
+// Low-level discovery objects, the client has no support for them
+type DiscoveryRule struct {
+	ItemId       string `json:"itemid,omitempty"`
+	HostId       string `json:"hostid,omitempty"`
+	Name         string `json:"name"`
+	Key          string `json:"key_"`
+	Type         string `json:"type"`
+	Lifetime     string `json:"lifetime,omitempty"`
+	TrapperHosts string `json:"trapper_hosts"`
+	Description  string `json:"description"`
+}
+
+type ItemPrototype struct {
+	ItemId       string `json:"itemid,omitempty"`
+	HostId       string `json:"hostid,omitempty"`
+	RuleId       string `json:"ruleid,omitempty"`
+	Name         string `json:"name"`
+	Key          string `json:"key_"`
+	Type         string `json:"type"`
+	ValueType    string `json:"value_type"`
+	History      string `json:"history,omitempty"`
+	Trends       string `json:"trends,omitempty"`
+	TrapperHosts string `json:"trapper_hosts"`
+	Description  string `json:"description"`
+	Tags         []Tag  `json:"tags,omitempty"`
+}
+
+type TriggerPrototype struct {
+	TriggerId   string `json:"triggerid,omitempty"`
+	Description string `json:"description"`
+	Expression  string `json:"expression"`
+	Comments    string `json:"comments"`
+	Priority    string `json:"priority"`
+	Tags        []Tag  `json:"tags,omitempty"`
+}
+
+type CustomDiscoveryRule struct {
+	State State
+	DiscoveryRule
+	ItemPrototypes    map[string]*CustomItemPrototype
+	TriggerPrototypes map[string]*CustomTriggerPrototype
+}
+
+type CustomItemPrototype struct {
+	State State
+	ItemPrototype
+}
+
+type CustomTriggerPrototype struct {
+	State State
+	TriggerPrototype
+}
+
+// Discovery needs the labels telling instances apart
+func (c *DiscoveryConfig) Validate() error {
+	if c.Enabled && len(c.Labels) == 0 {
+		return fmt.Errorf("discovery needs at least one label")
+	}
+	return nil
+}
+
+// Get the labels identifying the instances of a rule: zabbix_discovery_labels annotation or discovery configuration of the host
+// No labels means no discovery for that rule
+func (p *Provisioner) GetDiscoveryLabels(hostConfig HostConfig, rule PrometheusRule) []string {
+
+	if value, ok := rule.Annotations["zabbix_discovery_labels"]; ok {
+		labels := []string{}
+		for _, label := range strings.Split(value, ",") {
+			if label = strings.TrimSpace(label); len(label) != 0 {
+				labels = append(labels, label)
+			}
+		}
+		return labels
+	}
+
+	if hostConfig.Discovery.Enabled {
+		return hostConfig.Discovery.Labels
+	}
+
+	return nil
+}
+
+// Low-level discovery macro of a label, {#INSTANCE} for instance
+func DiscoveryMacro(label string) string {
+	return "{#" + macroInvalidCharacters.ReplaceAllString(strings.ToUpper(label), "_") + "}"
+}
+
+// Build the discovery rule of a rule from its item and trigger, the item and trigger prototypes get the same fields
+func (p *Provisioner) NewDiscoveryRule(target *CustomHost, hostConfig HostConfig, rule PrometheusRule, item *CustomItem, trigger *CustomTrigger, labels []string) *CustomDiscoveryRule {
+
+	macros := make([]string, 0, len(labels))
+	for _, label := range labels {
+		macros = append(macros, DiscoveryMacro(label))
+	}
+	instance := strings.Join(macros, " ")
+	key := fmt.Sprintf("%s[%s]", item.Key, strings.Join(macros, ","))
+	itemTags := TagList(item.Tags)
+	triggerTags := TagList(trigger.Tags)
+
+	discoveryRule := &CustomDiscoveryRule{
+		State: StateNew,
+		DiscoveryRule: DiscoveryRule{
+			Name:         fmt.Sprintf("%s instances", item.Name),
+			Key:          item.Key + DiscoveryKeySuffix,
+			Type:         "2", //Trapper
+			Lifetime:     hostConfig.Discovery.Lifetime,
+			TrapperHosts: item.TrapperHosts,
+			Description:  fmt.Sprintf("Instances of %s, by %s", rule.Name, strings.Join(labels, ", ")),
+		},
+		ItemPrototypes:    map[string]*CustomItemPrototype{},
+		TriggerPrototypes: map[string]*CustomTriggerPrototype{},
+	}
+
+	discoveryRule.AddItemPrototype(&CustomItemPrototype{
+		State: StateNew,
+		ItemPrototype: ItemPrototype{
+			Name:         fmt.Sprintf("%s %s", item.Name, instance),
+			Key:          key,
+			Type:         "2", //Trapper
+			ValueType:    "3",
+			History:      item.History,
+			Trends:       item.Trends,
+			TrapperHosts: item.TrapperHosts,
+			Description:  item.Description,
+			Tags:         itemTags,
+		},
+	})
+
+	discoveryRule.AddTriggerPrototype(&CustomTriggerPrototype{
+		State: StateNew,
+		TriggerPrototype: TriggerPrototype{
+			Description: fmt.Sprintf("%s %s", trigger.Description, instance),
+			Expression:  p.TriggerFunction(target.Name, key, "last", "") + "<>0",
+			Comments:    trigger.Comments,
+			Priority:    strconv.Itoa(int(trigger.Priority)),
+			Tags:        triggerTags,
+		},
+	})
+
+	// Add the special "No Data" trigger if requested
+	if delay, ok := rule.Annotations["zabbix_trigger_nodata"]; ok {
+		discoveryRule.AddTriggerPrototype(&CustomTriggerPrototype{
+			State: StateNew,
+			TriggerPrototype: TriggerPrototype{
+				Description: fmt.Sprintf("%s %s - no data for the last %s seconds", trigger.Description, instance, delay),
+				Expression:  p.TriggerFunction(target.Name, key, "nodata", delay),
+				Comments:    trigger.Comments,
+				Priority:    strconv.Itoa(int(trigger.Priority)),
+				Tags:        triggerTags,
+			},
+		})
+	}
+
+	return discoveryRule
+}
+
+func (host *CustomHost) AddDiscoveryRule(discoveryRule *CustomDiscoveryRule) *CustomDiscoveryRule {
+
+	if host.DiscoveryRules == nil {
+		host.DiscoveryRules = map[string]*CustomDiscoveryRule{}
+	}
+
+	if existing, ok := host.DiscoveryRules[discoveryRule.Key]; ok && discoveryRule.State == StateOld {
+		existing.ItemId = discoveryRule.ItemId
+		if existing.Equal(discoveryRule) {
+			existing.State = StateEqual
+		} else {
+			existing.State = StateUpdated
+		}
+		return existing
+	}
+
+	host.DiscoveryRules[discoveryRule.Key] = discoveryRule
+	return discoveryRule
+}
+
+func (discoveryRule *CustomDiscoveryRule) AddItemPrototype(itemPrototype *CustomItemPrototype) {
+
+	if existing, ok := discoveryRule.ItemPrototypes[itemPrototype.Key]; ok && itemPrototype.State == StateOld {
+		existing.ItemId = itemPrototype.ItemId
+		if existing.Equal(itemPrototype) {
+			existing.State = StateEqual
+		} else {
+			existing.State = StateUpdated
+		}
+		return
+	}
+
+	discoveryRule.ItemPrototypes[itemPrototype.Key] = itemPrototype
+}
+
+func (discoveryRule *CustomDiscoveryRule) AddTriggerPrototype(triggerPrototype *CustomTriggerPrototype) {
+
+	expression := NormalizeExpression(triggerPrototype.Expression)
+
+	if existing, ok := discoveryRule.TriggerPrototypes[expression]; ok && triggerPrototype.State == StateOld {
+		existing.TriggerId = triggerPrototype.TriggerId
+		if existing.Equal(triggerPrototype) {
+			existing.State = StateEqual
+		} else {
+			existing.State = StateUpdated
+		}
+		return
+	}
+
+	discoveryRule.TriggerPrototypes[expression] = triggerPrototype
+}
+
+func (i *CustomDiscoveryRule) Equal(j *CustomDiscoveryRule) bool {
+	// Zabbix sets its default lifetime when none is configured
+	return i.Name == j.Name &&
+		(len(i.Lifetime) == 0 || i.Lifetime == j.Lifetime) &&
+		i.TrapperHosts == j.TrapperHosts &&
+		i.Description == j.Description
+}
+
+func (i *CustomItemPrototype) Equal(j *CustomItemPrototype) bool {
+	return i.Name == j.Name &&
+		i.History == j.History &&
+		i.Trends == j.Trends &&
+		i.TrapperHosts == j.TrapperHosts &&
+		i.Description == j.Description &&
+		EqualTags(NewTagSet(i.Tags), NewTagSet(j.Tags))
+}
+
+func (i *CustomTriggerPrototype) Equal(j *CustomTriggerPrototype) bool {
+	return i.Description == j.Description &&
+		i.Comments == j.Comments &&
+		i.Priority == j.Priority &&
+		EqualTags(NewTagSet(i.Tags), NewTagSet(j.Tags))
+}
+
+// Load the managed discovery rules of a host or a template, with their prototypes
+func (p *Provisioner) FillDiscoveryRulesFromZabbix(oldHost *CustomHost) error {
+
+	zabbixDiscoveryRules := []DiscoveryRule{}
+	err := p.CallRaw("discoveryrule.get", zabbix.Params{
+		"output":    "extend",
+		"hostids":   oldHost.HostId,
+		"inherited": false,
+	}, &zabbixDiscoveryRules)
+	if err != nil {
+		return fmt.Errorf("getting discovery rules of host %s: %s", oldHost.Name, err)
+	}
+
+	for _, zabbixDiscoveryRule := range zabbixDiscoveryRules {
+
+		if !strings.HasPrefix(zabbixDiscoveryRule.Key, p.KeyPrefix()) {
+			log.Debugf("Ignoring discovery rule not managed by the provisioner: %s", zabbixDiscoveryRule.Key)
+			continue
+		}
+
+		discoveryRule := oldHost.AddDiscoveryRule(&CustomDiscoveryRule{
+			State:             StateOld,
+			DiscoveryRule:     zabbixDiscoveryRule,
+			ItemPrototypes:    map[string]*CustomItemPrototype{},
+			TriggerPrototypes: map[string]*CustomTriggerPrototype{},
+		})
+		log.Debugf("Discovery rule from Zabbix: %+v", discoveryRule)
+
+		itemParams := zabbix.Params{
+			"output":       "extend",
+			"discoveryids": zabbixDiscoveryRule.ItemId,
+		}
+		triggerParams := zabbix.Params{
+			"output":           "extend",
+			"discoveryids":     zabbixDiscoveryRule.ItemId,
+			"expandExpression": true,
+		}
+		if p.UseTags() {
+			itemParams["selectTags"] = "extend"
+		}
+		if p.UseTriggerTags() {
+			triggerParams["selectTags"] = "extend"
+		}
+
+		zabbixItemPrototypes := []ItemPrototype{}
+		err = p.CallRaw("itemprototype.get", itemParams, &zabbixItemPrototypes)
+		if err != nil {
+			return fmt.Errorf("getting item prototypes of discovery rule %s: %s", zabbixDiscoveryRule.Key, err)
+		}
+
+		for _, zabbixItemPrototype := range zabbixItemPrototypes {
+			discoveryRule.AddItemPrototype(&CustomItemPrototype{
+				State:         StateOld,
+				ItemPrototype: zabbixItemPrototype,
+			})
+		}
+
+		zabbixTriggerPrototypes := []TriggerPrototype{}
+		err = p.CallRaw("triggerprototype.get", triggerParams, &zabbixTriggerPrototypes)
+		if err != nil {
+			return fmt.Errorf("getting trigger prototypes of discovery rule %s: %s", zabbixDiscoveryRule.Key, err)
+		}
+
+		for _, zabbixTriggerPrototype := range zabbixTriggerPrototypes {
+			discoveryRule.AddTriggerPrototype(&CustomTriggerPrototype{
+				State:            StateOld,
+				TriggerPrototype: zabbixTriggerPrototype,
+			})
+		}
+	}
+
+	return nil
+}
+
+// Apply the changes of the discovery rules of a host or a template, deleting a discovery rule deletes its prototypes
+func (p *Provisioner) ApplyDiscoveryRules(host *CustomHost) error {
+
+	oldIds := []string{}
+	for _, discoveryRule := range host.DiscoveryRules {
+		if discoveryRule.State == StateOld {
+			oldIds = append(oldIds, discoveryRule.ItemId)
+		}
+	}
+
+	if len(oldIds) != 0 {
+		log.Debugf("Deleting discovery rules: %+v\n", oldIds)
+		err := p.CallRaw("discoveryrule.delete", oldIds, nil)
+		if err != nil {
+			return fmt.Errorf("deleting discovery rules of host %s: %s", host.Name, err)
+		}
+		CountChanges("discoveryrule", ActionDelete, len(oldIds))
+	}
+
+	for _, discoveryRule := range host.DiscoveryRules {
+
+		switch discoveryRule.State {
+		case StateOld:
+			continue
+		case StateNew:
+			discoveryRule.HostId = host.HostId
+			var result struct {
+				ItemIds []string `json:"itemids"`
+			}
+			err := p.CallRaw("discoveryrule.create", discoveryRule.DiscoveryRule, &result)
+			if err != nil {
+				return fmt.Errorf("creating discovery rule %s of host %s: %s", discoveryRule.Key, host.Name, err)
+			}
+			if len(result.ItemIds) != 1 {
+				return fmt.Errorf("discoveryrule.create returned %d ids for discovery rule %s", len(result.ItemIds), discoveryRule.Key)
+			}
+			discoveryRule.ItemId = result.ItemIds[0]
+			CountChanges("discoveryrule", ActionCreate, 1)
+		case StateUpdated:
+			update := discoveryRule.DiscoveryRule
+			update.HostId = ""
+			err := p.CallRaw("discoveryrule.update", update, nil)
+			if err != nil {
+				return fmt.Errorf("updating discovery rule %s of host %s: %s", discoveryRule.Key, host.Name, err)
+			}
+			CountChanges("discoveryrule", ActionUpdate, 1)
+		}
+
+		err := p.ApplyPrototypes(host, discoveryRule)
+		if err != nil {
+			return fmt.Errorf("discovery rule %s of host %s: %s", discoveryRule.Key, host.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply the changes of the prototypes of a discovery rule, in the same order as for items and triggers
+func (p *Provisioner) ApplyPrototypes(host *CustomHost, discoveryRule *CustomDiscoveryRule) error {
+
+	itemPrototypesByState := map[State][]ItemPrototype{}
+	for _, itemPrototype := range discoveryRule.ItemPrototypes {
+		itemPrototypesByState[itemPrototype.State] = append(itemPrototypesByState[itemPrototype.State], itemPrototype.ItemPrototype)
+	}
+
+	triggerPrototypesByState := map[State][]TriggerPrototype{}
+	for _, triggerPrototype := range discoveryRule.TriggerPrototypes {
+		triggerPrototypesByState[triggerPrototype.State] = append(triggerPrototypesByState[triggerPrototype.State], triggerPrototype.TriggerPrototype)
+	}
+
+	if triggerPrototypes := triggerPrototypesByState[StateOld]; len(triggerPrototypes) != 0 {
+		ids := make([]string, 0, len(triggerPrototypes))
+		for _, triggerPrototype := range triggerPrototypes {
+			ids = append(ids, triggerPrototype.TriggerId)
+		}
+		err := p.CallRaw("triggerprototype.delete", ids, nil)
+		if err != nil {
+			return fmt.Errorf("deleting trigger prototypes: %s", err)
+		}
+		CountChanges("triggerprototype", ActionDelete, len(ids))
+	}
+
+	if itemPrototypes := itemPrototypesByState[StateOld]; len(itemPrototypes) != 0 {
+		ids := make([]string, 0, len(itemPrototypes))
+		for _, itemPrototype := range itemPrototypes {
+			ids = append(ids, itemPrototype.ItemId)
+		}
+		err := p.CallRaw("itemprototype.delete", ids, nil)
+		if err != nil {
+			return fmt.Errorf("deleting item prototypes: %s", err)
+		}
+		CountChanges("itemprototype", ActionDelete, len(ids))
+	}
+
+	if itemPrototypes := itemPrototypesByState[StateUpdated]; len(itemPrototypes) != 0 {
+		for i := range itemPrototypes {
+			itemPrototypes[i].HostId = ""
+		}
+		err := p.CallRaw("itemprototype.update", itemPrototypes, nil)
+		if err != nil {
+			return fmt.Errorf("updating item prototypes: %s", err)
+		}
+		CountChanges("itemprototype", ActionUpdate, len(itemPrototypes))
+	}
+
+	if itemPrototypes := itemPrototypesByState[StateNew]; len(itemPrototypes) != 0 {
+		for i := range itemPrototypes {
+			itemPrototypes[i].HostId = host.HostId
+			itemPrototypes[i].RuleId = discoveryRule.ItemId
+		}
+		err := p.CallRaw("itemprototype.create", itemPrototypes, nil)
+		if err != nil {
+			return fmt.Errorf("creating item prototypes: %s", err)
+		}
+		CountChanges("itemprototype", ActionCreate, len(itemPrototypes))
+	}
+
+	if triggerPrototypes := triggerPrototypesByState[StateUpdated]; len(triggerPrototypes) != 0 {
+		err := p.CallRaw("triggerprototype.update", triggerPrototypes, nil)
+		if err != nil {
+			return fmt.Errorf("updating trigger prototypes: %s", err)
+		}
+		CountChanges("triggerprototype", ActionUpdate, len(triggerPrototypes))
+	}
+
+	if triggerPrototypes := triggerPrototypesByState[StateNew]; len(triggerPrototypes) != 0 {
+		err := p.CallRaw("triggerprototype.create", triggerPrototypes, nil)
+		if err != nil {
+			return fmt.Errorf("creating trigger prototypes: %s", err)
+		}
+		CountChanges("triggerprototype", ActionCreate, len(triggerPrototypes))
+	}
+
+	return nil
+}
+
+// Add the changes of a discovery rule and its prototypes to a plan
+func (plan *Plan) AddDiscoveryRule(host *CustomHost, discoveryRule *CustomDiscoveryRule) {
+
+	actions := map[State]string{
+		StateNew:     ActionCreate,
+		StateUpdated: ActionUpdate,
+		StateOld:     ActionDelete,
+	}
+
+	if action, ok := actions[discoveryRule.State]; ok {
+		plan.Add(Change{Action: action, Type: "discoveryrule", Host: host.Name, Name: discoveryRule.Key})
+	}
+
+	for _, itemPrototype := range discoveryRule.ItemPrototypes {
+		if action, ok := actions[itemPrototype.State]; ok {
+			plan.Add(Change{Action: action, Type: "itemprototype", Host: host.Name, Name: itemPrototype.Key})
+		}
+	}
+
+	for _, triggerPrototype := range discoveryRule.TriggerPrototypes {
+		if action, ok := actions[triggerPrototype.State]; ok {
+			plan.Add(Change{Action: action, Type: "triggerprototype", Host: host.Name, Name: triggerPrototype.Expression})
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package provisioner
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGetDiscoveryLabels(t *testing.T) {
+
+	p := &Provisioner{}
+	hostConfig := HostConfig{Discovery: DiscoveryConfig{Enabled: true, Labels: []string{"instance"}}}
+
+	tests := []struct {
+		name        string
+		hostConfig  HostConfig
+		annotations map[string]string
+		expected    []string
+	}{
+		{name: "host configuration", hostConfig: hostConfig, expected: []string{"instance"}},
+		{name: "discovery disabled", hostConfig: HostConfig{Discovery: DiscoveryConfig{Labels: []string{"instance"}}}},
+		{name: "annotation", annotations: map[string]string{"zabbix_discovery_labels": " namespace, pod ,"}, expected: []string{"namespace", "pod"}},
+		{name: "annotation disabling discovery", hostConfig: hostConfig, annotations: map[string]string{"zabbix_discovery_labels": ""}, expected: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			labels := p.GetDiscoveryLabels(test.hostConfig, PrometheusRule{Annotations: test.annotations})
+			if len(labels) != len(test.expected) || (len(labels) != 0 && !reflect.DeepEqual(labels, test.expected)) {
+				t.Errorf("expected labels %v, got %v", test.expected, labels)
+			}
+		})
+	}
+}
+
+func TestDiscoveryMacro(t *testing.T) {
+
+	tests := map[string]string{
+		"instance":        "{#INSTANCE}",
+		"kubernetes_pod":  "{#KUBERNETES_POD}",
+		"app.kubernetes":  "{#APP.KUBERNETES}",
+		"label-with-dash": "{#LABEL_WITH_DASH}",
+	}
+
+	for label, expected := range tests {
+		if macro := DiscoveryMacro(label); macro != expected {
+			t.Errorf("%q: expected %q, got %q", label, expected, macro)
+		}
+	}
+}
+
+func TestFillFromPrometheusDiscovery(t *testing.T) {
+
+	p := &Provisioner{
+		Config: ProvisionerConfig{
+			ZabbixKeyPrefix: "prometheus",
+			ZabbixHosts: []HostConfig{{
+				Name:      "k8s",
+				MatchAll:  true,
+				Discovery: DiscoveryConfig{Enabled: true, Labels: []string{"namespace", "pod"}},
+			}},
+		},
+		RuleSources: []RuleSource{&namedRuleSource{name: "prod", rules: []PrometheusRule{
+			{Name: "PodCrashLooping", Annotations: map[string]string{"zabbix_trigger_nodata": "600"}},
+			{Name: "Watchdog", Annotations: map[string]string{"zabbix_discovery_labels": ""}},
+		}}},
+		CustomZabbix:  NewCustomZabbix(),
+		ZabbixVersion: &ZabbixVersion{Major: 6, Minor: 0},
+	}
+
+	err := p.FillFromPrometheus()
+	if err != nil {
+		t.Fatalf("FillFromPrometheus: %s", err)
+	}
+
+	host := p.Hosts["k8s"]
+
+	// Rules without labels keep a single item
+	if _, ok := host.Items["prometheus.watchdog"]; !ok || len(host.Items) != 1 {
+		t.Errorf("expected the single item prometheus.watchdog, got %+v", host.Items)
+	}
+
+	discoveryRule, ok := host.DiscoveryRules["prometheus.podcrashlooping"+DiscoveryKeySuffix]
+	if !ok || len(host.DiscoveryRules) != 1 {
+		t.Fatalf("expected the discovery rule of PodCrashLooping, got %+v", host.DiscoveryRules)
+	}
+
+	itemPrototypeKey := "prometheus.podcrashlooping[{#NAMESPACE},{#POD}]"
+	if _, ok := discoveryRule.ItemPrototypes[itemPrototypeKey]; !ok || len(discoveryRule.ItemPrototypes) != 1 {
+		t.Errorf("expected the item prototype %s, got %+v", itemPrototypeKey, discoveryRule.ItemPrototypes)
+	}
+
+	expressions := []string{}
+	for expression := range discoveryRule.TriggerPrototypes {
+		expressions = append(expressions, expression)
+	}
+	expected := []string{
+		"last(/k8s/" + itemPrototypeKey + ")<>0",
+		"nodata(/k8s/" + itemPrototypeKey + ",600)",
+	}
+	sort.Strings(expressions)
+	if !reflect.DeepEqual(expressions, expected) {
+		t.Errorf("expected trigger prototypes %v, got %v", expected, expressions)
+	}
+}
+
+func TestAddDiscoveryRule(t *testing.T) {
+
+	host := &CustomHost{}
+	host.AddDiscoveryRule(&CustomDiscoveryRule{State: StateNew, DiscoveryRule: DiscoveryRule{Key: "prometheus.nodedown.discovery", Name: "NodeDown instances"}})
+
+	// Zabbix sets its default lifetime when none is configured
+	discoveryRule := host.AddDiscoveryRule(&CustomDiscoveryRule{
+		State:         StateOld,
+		DiscoveryRule: DiscoveryRule{ItemId: "42", Key: "prometheus.nodedown.discovery", Name: "NodeDown instances", Lifetime: "30d"},
+	})
+	if discoveryRule.State != StateEqual || discoveryRule.ItemId != "42" {
+		t.Errorf("expected the rule with the default lifetime to be equal, got %+v", discoveryRule)
+	}
+
+	discoveryRule = host.AddDiscoveryRule(&CustomDiscoveryRule{
+		State:         StateOld,
+		DiscoveryRule: DiscoveryRule{ItemId: "42", Key: "prometheus.nodedown.discovery", Name: "NodeDown"},
+	})
+	if discoveryRule.State != StateUpdated {
+		t.Errorf("expected the renamed rule to be updated, got %+v", discoveryRule)
+	}
+}
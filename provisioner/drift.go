@@ -0,0 +1,424 @@
+package provisioner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	// Overwrite manual changes with the desired state
+	DriftPolicyRevert = "revert"
+	// Leave manual changes in place
+	DriftPolicyKeep = "keep"
+	// Leave manual changes in place and report them as warnings
+	DriftPolicyAlert = "alert"
+)
+
+// Detection of the changes made by hand in Zabbix on managed items and triggers
+type DriftConfig struct {
+	// Policy of the objects without a host or rule level policy
+	Policy string `yaml:"policy"`
+	// Seconds between drift checks, those always query Zabbix even when rules are unchanged
+	Interval int `yaml:"interval"`
+}
+
+const (
+	// Changed in Zabbix while the desired state stayed the same
+	DriftCauseManual = "manual"
+	// Deleted from Zabbix while the desired state stayed the same
+	DriftCauseDeleted = "deleted"
+	// Different in Zabbix without a recorded desired state to tell a manual change from a rule change
+	DriftCauseUnrecorded = "unrecorded"
+	// Not a drift, the desired state changed since it was last applied
+	DriftCauseRules = "rules"
+	// Not a drift, missing in Zabbix without a recorded desired state, from a new rule or deleted by hand
+	DriftCauseMissing = "missing"
+)
+
+// Last line of the description of an item and of the comments of a trigger, with a hash of its desired fields when
+// it was last written, so that drifts are told apart from rule changes after a restart
+// Not a tag, Zabbix copies tags to the problems where they would get in the way of the actions
+const DesiredMarker = "provisioner_desired: "
+
+// Tag that held the hash before DesiredMarker, still read and removed from the objects carrying it
+const LegacyDesiredTag = "provisioner_desired"
+
+// Fields of a managed object that differ from the desired ones, "Old" being the value found in Zabbix
+type Drift struct {
+	Type   string        `json:"type"`
+	Host   string        `json:"host"`
+	Name   string        `json:"name"`
+	Policy string        `json:"policy"`
+	Cause  string        `json:"cause"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// Check if a difference comes from Zabbix rather than from the rules
+func (d Drift) IsManual() bool {
+	return d.Cause == DriftCauseManual || d.Cause == DriftCauseDeleted || d.Cause == DriftCauseUnrecorded
+}
+
+type DriftReport struct {
+	Time   time.Time `json:"time"`
+	Drifts []Drift   `json:"drifts"`
+	// Differences coming from the rules, only filled by the drift command
+	Pending []Drift `json:"pending,omitempty"`
+}
+
+func IsValidDriftPolicy(policy string) bool {
+	switch policy {
+	case DriftPolicyRevert, DriftPolicyKeep, DriftPolicyAlert:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get the drift policy of the objects of a rule: zabbix_drift_policy annotation, host driftPolicy or drift.policy
+func (p *Provisioner) GetDriftPolicy(hostConfig HostConfig, rule PrometheusRule) string {
+
+	if policy, ok := rule.Annotations["zabbix_drift_policy"]; ok {
+		if IsValidDriftPolicy(policy) {
+			return policy
+		}
+		log.Warnf("Rule %s has an unknown drift policy %q, using the host one", rule.Name, policy)
+	}
+
+	if len(hostConfig.DriftPolicy) != 0 {
+		return hostConfig.DriftPolicy
+	}
+
+	return p.Config.Drift.Policy
+}
+
+// Find the managed items and triggers changed or deleted by hand in Zabbix
+func (z *CustomZabbix) Drift(lastDesired map[string]string) *DriftReport {
+
+	report := &DriftReport{Time: time.Now(), Drifts: []Drift{}}
+	for _, difference := range z.Differences(lastDesired) {
+		if difference.IsManual() {
+			report.Drifts = append(report.Drifts, difference)
+		}
+	}
+
+	return report
+}
+
+// Find the managed items and triggers whose Zabbix state differs from the desired one, with the cause of each difference
+// The desired state recorded in the DesiredMarker of the object, or else in lastDesired by the previous cycle,
+// tells manual changes from rule changes
+func (z *CustomZabbix) Differences(lastDesired map[string]string) []Drift {
+
+	differences := []Drift{}
+
+	for _, host := range z.HostsAndTemplates() {
+		for _, item := range host.Items {
+			drift := Drift{Type: "item", Host: host.Name, Name: item.Key, Policy: item.DriftPolicy}
+
+			switch {
+			case item.State == StateUpdated && item.Current != nil:
+				drift.Fields = DiffItems(item.Current.withoutDesired(), item.withoutDesired())
+				recorded, ok := RecordedDesired(item.Current.Description, item.Current.Tags, lastDesired, item.DesiredKey(host))
+				drift.Cause = differenceCause(len(drift.Fields) != 0, recorded, ok, item.DesiredHash())
+			case item.State == StateNew && host.State != StateNew:
+				drift.Fields = DiffItems(&CustomItem{}, item.withoutDesired())
+				recorded, ok := lastDesired[item.DesiredKey(host)]
+				drift.Cause = missingCause(recorded, ok, item.DesiredHash())
+			}
+
+			if len(drift.Cause) != 0 {
+				differences = append(differences, drift)
+			}
+		}
+
+		for _, trigger := range host.Triggers {
+			drift := Drift{Type: "trigger", Host: host.Name, Name: trigger.Expression, Policy: trigger.DriftPolicy}
+
+			switch {
+			case trigger.State == StateUpdated && trigger.Current != nil:
+				drift.Fields = DiffTriggers(trigger.Current.withoutDesired(), trigger.withoutDesired())
+				recorded, ok := RecordedDesired(trigger.Current.Comments, trigger.Current.Tags, lastDesired, trigger.DesiredKey(host))
+				drift.Cause = differenceCause(len(drift.Fields) != 0, recorded, ok, trigger.DesiredHash())
+			case trigger.State == StateNew && host.State != StateNew:
+				drift.Fields = DiffTriggers(&CustomTrigger{}, trigger.withoutDesired())
+				recorded, ok := lastDesired[trigger.DesiredKey(host)]
+				drift.Cause = missingCause(recorded, ok, trigger.DesiredHash())
+			}
+
+			if len(drift.Cause) != 0 {
+				differences = append(differences, drift)
+			}
+		}
+	}
+
+	return differences
+}
+
+// Cause of the difference of an object found in Zabbix, none when only its DesiredMarker differs
+func differenceCause(changed bool, recorded string, ok bool, desired string) string {
+	switch {
+	case !changed:
+		return ""
+	case !ok:
+		return DriftCauseUnrecorded
+	case recorded != desired:
+		return DriftCauseRules
+	default:
+		return DriftCauseManual
+	}
+}
+
+// Cause of an object missing in Zabbix, only the previous cycle can tell it was deleted
+func missingCause(recorded string, ok bool, desired string) string {
+	switch {
+	case !ok:
+		return DriftCauseMissing
+	case recorded != desired:
+		return DriftCauseRules
+	default:
+		return DriftCauseDeleted
+	}
+}
+
+// Get the desired state recorded for an object, from its DesiredMarker (or LegacyDesiredTag) in Zabbix or from the previous cycle
+func RecordedDesired(text string, tags map[Tag]struct{}, lastDesired map[string]string, key string) (string, bool) {
+
+	if _, hash, ok := splitDesired(text); ok {
+		return hash, true
+	}
+
+	for tag := range tags {
+		if tag.Tag == LegacyDesiredTag {
+			return tag.Value, true
+		}
+	}
+
+	recorded, ok := lastDesired[key]
+	return recorded, ok
+}
+
+// Hashes of the desired fields of the managed items and triggers, to tell drifts from rule changes in the next cycles
+// Kept objects without a recorded desired state get the hash of their state in Zabbix instead, see KeepDrift
+func (z *CustomZabbix) DesiredState() map[string]string {
+
+	desired := map[string]string{}
+	for _, host := range z.HostsAndTemplates() {
+		for _, item := range host.Items {
+			switch {
+			case item.State == StateOld:
+			case len(item.KeptHash) != 0:
+				desired[item.DesiredKey(host)] = item.KeptHash
+			default:
+				desired[item.DesiredKey(host)] = item.DesiredHash()
+			}
+		}
+		for _, trigger := range host.Triggers {
+			switch {
+			case trigger.State == StateOld:
+			case len(trigger.KeptHash) != 0:
+				desired[trigger.DesiredKey(host)] = trigger.KeptHash
+			default:
+				desired[trigger.DesiredKey(host)] = trigger.DesiredHash()
+			}
+		}
+	}
+	return desired
+}
+
+// Record the desired fields of an item at the end of its description
+func RecordDesiredItem(item *CustomItem) {
+	item.Description = withDesired(item.Description, item.DesiredHash())
+}
+
+// Record the desired fields of a trigger at the end of its comments
+func RecordDesiredTrigger(trigger *CustomTrigger) {
+	trigger.Comments = withDesired(trigger.Comments, trigger.DesiredHash())
+}
+
+func (item *CustomItem) DesiredKey(host *CustomHost) string {
+	return fmt.Sprintf("item/%s/%s", host.Name, item.Key)
+}
+
+func (item *CustomItem) DesiredHash() string {
+	return hashFields(DiffItems(&CustomItem{}, item.withoutDesired()))
+}
+
+func (item *CustomItem) withoutDesired() *CustomItem {
+	copy := *item
+	copy.Description = withoutDesired(item.Description)
+	copy.Tags = withoutLegacyDesiredTag(item.Tags)
+	return &copy
+}
+
+func (trigger *CustomTrigger) DesiredKey(host *CustomHost) string {
+	return fmt.Sprintf("trigger/%s/%s", host.Name, NormalizeExpression(trigger.Expression))
+}
+
+func (trigger *CustomTrigger) DesiredHash() string {
+	// The expression syntax depends on the Zabbix version, not on the rules
+	copy := *trigger.withoutDesired()
+	copy.Expression = NormalizeExpression(trigger.Expression)
+	return hashFields(DiffTriggers(&CustomTrigger{}, &copy))
+}
+
+func (trigger *CustomTrigger) withoutDesired() *CustomTrigger {
+	copy := *trigger
+	copy.Comments = withoutDesired(trigger.Comments)
+	copy.Tags = withoutLegacyDesiredTag(trigger.Tags)
+	return &copy
+}
+
+func hashFields(fields []FieldChange) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(fields)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Split a description or comments from the hash recorded on their last line
+func splitDesired(text string) (string, string, bool) {
+
+	index := strings.LastIndex(text, DesiredMarker)
+	if index < 0 || strings.Contains(text[index:], "\n") {
+		return text, "", false
+	}
+
+	switch {
+	case index == 0:
+		return "", text[len(DesiredMarker):], true
+	case strings.HasSuffix(text[:index], "\n\n"):
+		return text[:index-2], text[index+len(DesiredMarker):], true
+	default:
+		return text, "", false
+	}
+}
+
+func withoutDesired(text string) string {
+	text, _, _ = splitDesired(text)
+	return text
+}
+
+func withDesired(text string, hash string) string {
+	text = withoutDesired(text)
+	if len(text) == 0 {
+		return DesiredMarker + hash
+	}
+	return text + "\n\n" + DesiredMarker + hash
+}
+
+func withoutLegacyDesiredTag(tags map[Tag]struct{}) map[Tag]struct{} {
+	if tags == nil {
+		return nil
+	}
+
+	result := make(map[Tag]struct{}, len(tags))
+	for tag := range tags {
+		if tag.Tag != LegacyDesiredTag {
+			result[tag] = struct{}{}
+		}
+	}
+	return result
+}
+
+// Report the drifts of the current cycle, and leave the drifted objects alone unless their policy is revert
+func (p *Provisioner) CheckDrift() *DriftReport {
+
+	report := p.CustomZabbix.Drift(p.LastDesired)
+
+	DriftedObjects.Reset()
+	for _, drift := range report.Drifts {
+		DriftedObjects.WithLabelValues(drift.Type, drift.Policy).Inc()
+
+		message := fmt.Sprintf("%s %s on host %s was changed in Zabbix: %s", drift.Type, drift.Name, drift.Host, FormatFieldChanges(drift.Fields))
+		switch drift.Cause {
+		case DriftCauseDeleted:
+			message = fmt.Sprintf("%s %s on host %s was deleted in Zabbix", drift.Type, drift.Name, drift.Host)
+		case DriftCauseUnrecorded:
+			message += " (no recorded desired state, it may come from the rules)"
+		}
+		switch drift.Policy {
+		case DriftPolicyKeep:
+			log.Infof("Keeping drift, %s", message)
+		case DriftPolicyAlert:
+			log.Warnf("DRIFT, %s", message)
+		default:
+			log.Infof("Reverting drift, %s", message)
+		}
+	}
+
+	p.CustomZabbix.KeepDrift(report)
+	p.LastDriftCheck = report.Time
+	p.Status.SetDrift(report)
+
+	return report
+}
+
+// Mark the drifted objects with a keep or alert policy as equal, so that they are not updated nor recreated
+// A kept object without a recorded desired state may differ because of the rules, the state found in Zabbix is
+// recorded for it so that the next cycle applies the difference as a rule change, instead of a manual one kept forever
+func (z *CustomZabbix) KeepDrift(report *DriftReport) {
+
+	for _, drift := range report.Drifts {
+		if drift.Policy != DriftPolicyKeep && drift.Policy != DriftPolicyAlert {
+			continue
+		}
+
+		host := z.GetHostOrTemplate(drift.Host)
+		switch drift.Type {
+		case "item":
+			item := host.Items[drift.Name]
+			if drift.Cause == DriftCauseUnrecorded {
+				item.KeptHash = item.Current.DesiredHash()
+			}
+			item.State = StateEqual
+		case "trigger":
+			trigger := host.Triggers[NormalizeExpression(drift.Name)]
+			if drift.Cause == DriftCauseUnrecorded {
+				trigger.KeptHash = trigger.Current.DesiredHash()
+			}
+			trigger.State = StateEqual
+		}
+	}
+}
+
+// Check if a drift check is due, it needs a full cycle
+func (p *Provisioner) IsDriftCheckDue() bool {
+	if p.Config.Drift.Interval == 0 {
+		return false
+	}
+	return time.Since(p.LastDriftCheck) >= time.Duration(p.Config.Drift.Interval)*time.Second
+}
+
+// Read the rules and Zabbix and report the drifts, along with the pending rule changes, without touching Zabbix
+func (p *Provisioner) Drift() (*DriftReport, error) {
+
+	err := p.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	p.CustomZabbix = NewCustomZabbix()
+
+	err = p.FillFromPrometheus()
+	if err != nil {
+		return nil, fmt.Errorf("reading rules: %s", err)
+	}
+
+	err = p.FillFromZabbix()
+	if err != nil {
+		return nil, fmt.Errorf("reading Zabbix: %s", err)
+	}
+
+	report := &DriftReport{Time: time.Now(), Drifts: []Drift{}, Pending: []Drift{}}
+	for _, difference := range p.CustomZabbix.Differences(p.LastDesired) {
+		if difference.IsManual() {
+			report.Drifts = append(report.Drifts, difference)
+		} else {
+			report.Pending = append(report.Pending, difference)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,161 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"testing"
+)
+
+func newDriftItem(state State, description string, hash string) *CustomItem {
+	item := &CustomItem{
+		State:       state,
+		Item:        zabbix.Item{Key: "prometheus.nodedown", Name: "NodeDown", Description: description},
+		DriftPolicy: DriftPolicyKeep,
+		Tags:        map[Tag]struct{}{},
+	}
+	if len(hash) != 0 {
+		item.Description = withDesired(description, hash)
+	}
+	return item
+}
+
+func TestDifferences(t *testing.T) {
+
+	desired := newDriftItem(StateNew, "rule", "")
+	desiredHash := desired.DesiredHash()
+	key := desired.DesiredKey(&CustomHost{Host: zabbix.Host{Host: "host", Name: "host"}})
+
+	tests := []struct {
+		name        string
+		hostState   State
+		current     *CustomItem
+		lastDesired map[string]string
+		cause       string
+	}{
+		{name: "edited by hand", hostState: StateEqual, current: newDriftItem(StateOld, "edited", desiredHash), cause: DriftCauseManual},
+		{name: "rule changed", hostState: StateEqual, current: newDriftItem(StateOld, "old rule", "0123456789abcdef"), cause: DriftCauseRules},
+		{name: "no record", hostState: StateEqual, current: newDriftItem(StateOld, "edited", ""), cause: DriftCauseUnrecorded},
+		{name: "recorded by the previous cycle", hostState: StateEqual, current: newDriftItem(StateOld, "edited", ""),
+			lastDesired: map[string]string{key: desiredHash}, cause: DriftCauseManual},
+		{name: "record missing only", hostState: StateEqual, current: newDriftItem(StateOld, "rule", ""), cause: ""},
+		{name: "recorded in the legacy tag", hostState: StateEqual, current: &CustomItem{State: StateOld,
+			Item: zabbix.Item{Key: "prometheus.nodedown", Name: "NodeDown", Description: "edited"},
+			Tags: map[Tag]struct{}{{Tag: LegacyDesiredTag, Value: desiredHash}: {}}}, cause: DriftCauseManual},
+		{name: "deleted by hand", hostState: StateEqual, lastDesired: map[string]string{key: desiredHash}, cause: DriftCauseDeleted},
+		{name: "new rule or deleted", hostState: StateEqual, cause: DriftCauseMissing},
+		{name: "rule changed while deleted", hostState: StateEqual, lastDesired: map[string]string{key: "0123456789abcdef"}, cause: DriftCauseRules},
+		{name: "new host", hostState: StateNew, cause: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			z := NewCustomZabbix()
+			host := z.AddHost(&CustomHost{State: test.hostState, Host: zabbix.Host{Host: "host", Name: "host"}, Items: map[string]*CustomItem{}})
+
+			item := newDriftItem(StateNew, "rule", "")
+			RecordDesiredItem(item)
+			host.AddItem(item)
+			if test.current != nil {
+				host.AddItem(test.current)
+			}
+
+			differences := z.Differences(test.lastDesired)
+			if len(test.cause) == 0 {
+				if len(differences) != 0 {
+					t.Fatalf("expected no difference, got %+v", differences)
+				}
+				return
+			}
+
+			if len(differences) != 1 || differences[0].Cause != test.cause {
+				t.Fatalf("expected a single difference caused by %s, got %+v", test.cause, differences)
+			}
+		})
+	}
+}
+
+func TestKeepDrift(t *testing.T) {
+
+	z := NewCustomZabbix()
+	host := z.AddHost(&CustomHost{State: StateEqual, Host: zabbix.Host{Host: "host", Name: "host"}, Items: map[string]*CustomItem{}})
+
+	item := newDriftItem(StateNew, "rule", "")
+	RecordDesiredItem(item)
+	host.AddItem(item)
+	host.AddItem(newDriftItem(StateOld, "edited", item.DesiredHash()))
+
+	z.KeepDrift(z.Drift(nil))
+	if item.State != StateEqual {
+		t.Errorf("expected the drifted item to be kept, got state %s", StateName[item.State])
+	}
+}
+
+func TestKeepDriftAcrossCycles(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		lastDesired func(key, hash string) map[string]string
+		causes      []string
+		states      []State
+	}{
+		// Without a record the difference may come from the rules, it is kept once and applied by the next cycle
+		{name: "unrecorded", lastDesired: func(key, hash string) map[string]string { return map[string]string{} },
+			causes: []string{DriftCauseUnrecorded, DriftCauseRules}, states: []State{StateEqual, StateUpdated}},
+		{name: "manual", lastDesired: func(key, hash string) map[string]string { return map[string]string{key: hash} },
+			causes: []string{DriftCauseManual, DriftCauseManual}, states: []State{StateEqual, StateEqual}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lastDesired map[string]string
+			for cycle := range test.causes {
+				z := NewCustomZabbix()
+				host := z.AddHost(&CustomHost{State: StateEqual, Host: zabbix.Host{Host: "host", Name: "host"}, Items: map[string]*CustomItem{}})
+
+				// Items provisioned before the desired state was recorded
+				item := newDriftItem(StateNew, "rule", "")
+				host.AddItem(item)
+				host.AddItem(newDriftItem(StateOld, "edited", ""))
+				if lastDesired == nil {
+					lastDesired = test.lastDesired(item.DesiredKey(host), item.DesiredHash())
+				}
+
+				differences := z.Differences(lastDesired)
+				if len(differences) != 1 || differences[0].Cause != test.causes[cycle] {
+					t.Fatalf("cycle %d: expected a single difference caused by %s, got %+v", cycle+1, test.causes[cycle], differences)
+				}
+
+				z.KeepDrift(z.Drift(lastDesired))
+				if item.State != test.states[cycle] {
+					t.Fatalf("cycle %d: expected state %s, got %s", cycle+1, StateName[test.states[cycle]], StateName[item.State])
+				}
+
+				lastDesired = z.DesiredState()
+			}
+		})
+	}
+}
+
+func TestSplitDesired(t *testing.T) {
+
+	tests := []struct {
+		text     string
+		expected string
+		hash     string
+	}{
+		{text: "Node down\n\nprovisioner_desired: 0123456789abcdef", expected: "Node down", hash: "0123456789abcdef"},
+		{text: "provisioner_desired: 0123456789abcdef", expected: "", hash: "0123456789abcdef"},
+		{text: "Node down\n\n", expected: "Node down\n\n"},
+		{text: "See provisioner_desired: in the README", expected: "See provisioner_desired: in the README"},
+		{text: "provisioner_desired: 0123456789abcdef\nedited", expected: "provisioner_desired: 0123456789abcdef\nedited"},
+	}
+
+	for _, test := range tests {
+		text, hash, _ := splitDesired(test.text)
+		if text != test.expected || hash != test.hash {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.text, test.expected, test.hash, text, hash)
+		}
+		if len(test.hash) != 0 && withDesired(text, hash) != test.text {
+			t.Errorf("%q: expected the text back, got %q", test.text, withDesired(text, hash))
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package provisioner
+
+import (
+	"fmt"
+)
+
+// Zabbix 5.4 replaced {host:key.function(parameters)} with function(/host/key,parameters) in trigger expressions
+func (p *Provisioner) UseNewExpressionSyntax() bool {
+	return p.ZabbixVersion != nil && p.ZabbixVersion.AtLeast(5, 4)
+}
+
+// Build a trigger function call with the syntax of the Zabbix server
+func (p *Provisioner) TriggerFunction(host string, key string, function string, parameters string) string {
+
+	if !p.UseNewExpressionSyntax() {
+		return fmt.Sprintf("{%s:%s.%s(%s)}", host, key, function, parameters)
+	}
+
+	if len(parameters) == 0 {
+		return fmt.Sprintf("%s(/%s/%s)", function, host, key)
+	}
+	return fmt.Sprintf("%s(/%s/%s,%s)", function, host, key, parameters)
+}
+
+// Rewrite the function calls of an expression with the old syntax to the new one, so that both compare equal
+func NormalizeExpression(expression string) string {
+
+	return triggerFunctionRegexp.ReplaceAllStringFunc(expression, func(call string) string {
+		match := triggerFunctionRegexp.FindStringSubmatch(call)
+		host, key, function, parameters := match[1], match[2], match[3], match[4]
+
+		if len(parameters) == 0 {
+			return fmt.Sprintf("%s(/%s/%s)", function, host, key)
+		}
+		return fmt.Sprintf("%s(/%s/%s,%s)", function, host, key, parameters)
+	})
+}
@@ -0,0 +1,91 @@
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeExpression(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   string
+	}{
+		{name: "last", expression: "{host:prometheus.nodedown.last()}<>0", expected: "last(/host/prometheus.nodedown)<>0"},
+		{name: "nodata", expression: "{host:prometheus.nodedown.nodata(100)}=1", expected: "nodata(/host/prometheus.nodedown,100)=1"},
+		{name: "template with spaces", expression: "{Template Prometheus:prometheus.a.last()}<>0", expected: "last(/Template Prometheus/prometheus.a)<>0"},
+		{name: "key parameters", expression: "{host:prometheus.a[x,y].last()}<>0", expected: "last(/host/prometheus.a[x,y])<>0"},
+		{name: "discovery macro", expression: "{host:prometheus.a[{#INSTANCE}].last()}<>0", expected: "last(/host/prometheus.a[{#INSTANCE}])<>0"},
+		{name: "several macros", expression: "{host:prometheus.a[{#INSTANCE},{#JOB}].last()}<>0", expected: "last(/host/prometheus.a[{#INSTANCE},{#JOB}])<>0"},
+		{name: "user macro parameter", expression: "{host:prometheus.a.nodata({$NODATA})}=1", expected: "nodata(/host/prometheus.a,{$NODATA})=1"},
+		{name: "several functions", expression: "{host:prometheus.a.last()}<>0 or {host:prometheus.b.last()}<>0",
+			expected: "last(/host/prometheus.a)<>0 or last(/host/prometheus.b)<>0"},
+		{name: "new syntax unchanged", expression: "last(/host/prometheus.a[{#INSTANCE}])<>0", expected: "last(/host/prometheus.a[{#INSTANCE}])<>0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if normalized := NormalizeExpression(test.expression); normalized != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, normalized)
+			}
+		})
+	}
+}
+
+func TestTriggerFunction(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		version    *ZabbixVersion
+		function   string
+		parameters string
+		expected   string
+	}{
+		{name: "old syntax", version: &ZabbixVersion{Major: 5, Minor: 0}, function: "last", expected: "{host:prometheus.a.last()}"},
+		{name: "old syntax with parameters", version: &ZabbixVersion{Major: 4, Minor: 0}, function: "nodata", parameters: "100", expected: "{host:prometheus.a.nodata(100)}"},
+		{name: "new syntax", version: &ZabbixVersion{Major: 5, Minor: 4}, function: "last", expected: "last(/host/prometheus.a)"},
+		{name: "new syntax with parameters", version: &ZabbixVersion{Major: 6, Minor: 0}, function: "nodata", parameters: "100", expected: "nodata(/host/prometheus.a,100)"},
+		{name: "unknown version", function: "last", expected: "{host:prometheus.a.last()}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{ZabbixVersion: test.version}
+
+			function := p.TriggerFunction("host", "prometheus.a", test.function, test.parameters)
+			if function != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, function)
+			}
+
+			// Both syntaxes give the same normalized expression
+			newSyntax := &Provisioner{ZabbixVersion: &ZabbixVersion{Major: 5, Minor: 4}}
+			if NormalizeExpression(function) != newSyntax.TriggerFunction("host", "prometheus.a", test.function, test.parameters) {
+				t.Errorf("%q does not normalize to the new syntax", function)
+			}
+		})
+	}
+}
+
+func TestTriggerItemKeys(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   []string
+	}{
+		{name: "old syntax", expression: "{host:prometheus.a.last()}<>0", expected: []string{"prometheus.a"}},
+		{name: "old syntax with a discovery macro", expression: "{host:prometheus.a[{#INSTANCE}].last()}<>0", expected: []string{"prometheus.a[{#INSTANCE}]"}},
+		{name: "new syntax", expression: "last(/host/prometheus.a)<>0 and nodata(/host/prometheus.b,60)=1", expected: []string{"prometheus.a", "prometheus.b"}},
+		{name: "new syntax with a discovery macro", expression: "last(/host/prometheus.a[{#INSTANCE}])<>0", expected: []string{"prometheus.a[{#INSTANCE}]"}},
+		{name: "no function", expression: "1=1", expected: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if keys := TriggerItemKeys(test.expression); !reflect.DeepEqual(keys, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package provisioner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Stable hash of the rules and the configuration, two cycles with the same fingerprint want the same Zabbix objects
+func Fingerprint(rules []PrometheusRule, cfg ProvisionerConfig) (string, error) {
+
+	// Sources don't always return the rules in the same order
+	sorted := append([]PrometheusRule{}, rules...)
+
+	// The health of a rule changes with its evaluations but has no effect on Zabbix
+	for i := range sorted {
+		sorted[i].Health = ""
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Query < b.Query
+	})
+
+	// Maps are marshalled with sorted keys
+	data, err := json.Marshal(struct {
+		Rules  []PrometheusRule
+		Config ProvisionerConfig
+	}{sorted, cfg})
+	if err != nil {
+		return "", fmt.Errorf("computing fingerprint: %s", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,128 @@
+package provisioner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+
+	rules := []PrometheusRule{
+		{Name: "NodeDown", Source: "prometheus", Group: "nodes", Query: "up == 0", Health: "ok",
+			Labels: map[string]string{"severity": "critical", "team": "infra"}},
+		{Name: "DiskFull", Source: "prometheus", Group: "nodes", Query: "disk_free < 0.1", Health: "ok"},
+		{Name: "NodeDown", Source: "cortex", Tenant: "team-a", Group: "nodes", Query: "up == 0", Health: "ok"},
+	}
+	cfg := ProvisionerConfig{ZabbixKeyPrefix: "prometheus"}
+
+	reference, err := Fingerprint(rules, cfg)
+	if err != nil {
+		t.Fatalf("Fingerprint: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		rules []PrometheusRule
+		cfg   ProvisionerConfig
+		equal bool
+	}{
+		{name: "same rules", rules: rules, cfg: cfg, equal: true},
+		{name: "other order", rules: []PrometheusRule{rules[2], rules[0], rules[1]}, cfg: cfg, equal: true},
+		{name: "reversed order", rules: []PrometheusRule{rules[2], rules[1], rules[0]}, cfg: cfg, equal: true},
+		{name: "other health", rules: withRule(rules, 0, func(rule *PrometheusRule) { rule.Health = "err" }), cfg: cfg, equal: true},
+		{name: "other labels map", rules: withRule(rules, 0, func(rule *PrometheusRule) {
+			rule.Labels = map[string]string{"team": "infra", "severity": "critical"}
+		}), cfg: cfg, equal: true},
+		{name: "other query", rules: withRule(rules, 1, func(rule *PrometheusRule) { rule.Query = "disk_free < 0.2" }), cfg: cfg, equal: false},
+		{name: "other label", rules: withRule(rules, 0, func(rule *PrometheusRule) {
+			rule.Labels = map[string]string{"severity": "warning", "team": "infra"}
+		}), cfg: cfg, equal: false},
+		{name: "missing rule", rules: rules[:2], cfg: cfg, equal: false},
+		{name: "other configuration", rules: rules, cfg: ProvisionerConfig{ZabbixKeyPrefix: "alerts"}, equal: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fingerprint, err := Fingerprint(test.rules, test.cfg)
+			if err != nil {
+				t.Fatalf("Fingerprint: %s", err)
+			}
+			if (fingerprint == reference) != test.equal {
+				t.Errorf("expected equal fingerprints %v, got %s and %s", test.equal, reference, fingerprint)
+			}
+		})
+	}
+
+	// The rules of the caller are left alone
+	if rules[0].Health != "ok" || rules[0].Source != "prometheus" {
+		t.Errorf("Fingerprint changed the rules: %+v", rules[0])
+	}
+}
+
+// Copy of a list of rules with one of them changed
+func withRule(rules []PrometheusRule, i int, change func(rule *PrometheusRule)) []PrometheusRule {
+	changed := append([]PrometheusRule{}, rules...)
+	change(&changed[i])
+	return changed
+}
+
+func TestIsSynced(t *testing.T) {
+
+	rules := []PrometheusRule{{Name: "NodeDown", Source: "prometheus", Query: "up == 0"}}
+	cfg := ProvisionerConfig{ZabbixKeyPrefix: "prometheus", FullResyncInterval: 3600}
+	cfg.Drift.Interval = 600
+
+	synced, err := Fingerprint(rules, cfg)
+	if err != nil {
+		t.Fatalf("Fingerprint: %s", err)
+	}
+	changedCfg := cfg
+	changedCfg.ZabbixKeyPrefix = "alerts"
+	changed, err := Fingerprint(rules, changedCfg)
+	if err != nil {
+		t.Fatalf("Fingerprint: %s", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name              string
+		cfg               ProvisionerConfig
+		fingerprint       string
+		syncedFingerprint string
+		lastFullSync      time.Time
+		lastDriftCheck    time.Time
+		orphanHosts       map[string]time.Time
+		expected          bool
+	}{
+		{name: "fingerprint unchanged", cfg: cfg, fingerprint: synced, syncedFingerprint: synced,
+			lastFullSync: now.Add(-time.Minute), lastDriftCheck: now.Add(-time.Minute), expected: true},
+		{name: "first cycle", cfg: cfg, fingerprint: synced, expected: false},
+		{name: "configuration change", cfg: changedCfg, fingerprint: changed, syncedFingerprint: synced,
+			lastFullSync: now.Add(-time.Minute), lastDriftCheck: now.Add(-time.Minute), expected: false},
+		{name: "full resync interval elapsed", cfg: cfg, fingerprint: synced, syncedFingerprint: synced,
+			lastFullSync: now.Add(-2 * time.Hour), lastDriftCheck: now.Add(-time.Minute), expected: false},
+		{name: "drift check due", cfg: cfg, fingerprint: synced, syncedFingerprint: synced,
+			lastFullSync: now.Add(-time.Minute), lastDriftCheck: now.Add(-20 * time.Minute), expected: false},
+		{name: "hosts waiting to be pruned", cfg: cfg, fingerprint: synced, syncedFingerprint: synced,
+			lastFullSync: now.Add(-time.Minute), lastDriftCheck: now.Add(-time.Minute),
+			orphanHosts: map[string]time.Time{"old": now}, expected: false},
+		{name: "skipping disabled", cfg: ProvisionerConfig{ZabbixKeyPrefix: "prometheus"}, fingerprint: synced, syncedFingerprint: synced,
+			lastFullSync: now.Add(-time.Minute), lastDriftCheck: now.Add(-time.Minute), expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{
+				Config:            test.cfg,
+				Fingerprint:       test.fingerprint,
+				SyncedFingerprint: test.syncedFingerprint,
+				LastFullSync:      test.lastFullSync,
+				LastDriftCheck:    test.lastDriftCheck,
+				OrphanHosts:       test.orphanHosts,
+			}
+			if synced := p.IsSynced(); synced != test.expected {
+				t.Errorf("expected synced %v, got %v", test.expected, synced)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package provisioner
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// Limits on the number of items and triggers a cycle may delete, 0 disables a limit
+// Protects against a rule source returning no or partial rules, which would remove everything from Zabbix
+// The percentage only applies to cycles deleting more than MinDeletions objects, so that small setups can still remove rules
+// A cycle deleting all the managed objects is always aborted, whatever the limits
+type DeletionGuardConfig struct {
+	MaxDeletions       int `yaml:"maxDeletions"`
+	MaxDeletionPercent int `yaml:"maxDeletionPercent"`
+	MinDeletions       int `yaml:"minDeletions"`
+}
+
+// Count the managed items and triggers currently in Zabbix, and the ones to be deleted
+func (z *CustomZabbix) CountDeletions() (existing int, deleted int) {
+
+	for _, host := range z.HostsAndTemplates() {
+		// Unlinking a managed template clears the items and triggers it brought to the host
+		_, unlink := z.GetTemplateLinks(host)
+		for _, name := range unlink {
+			existing += z.TemplateObjects[name]
+			deleted += z.TemplateObjects[name]
+		}
+
+		for _, item := range host.Items {
+			if item.State != StateNew {
+				existing++
+			}
+			if item.State == StateOld {
+				deleted++
+			}
+		}
+
+		for _, trigger := range host.Triggers {
+			if trigger.State != StateNew {
+				existing++
+			}
+			if trigger.State == StateOld {
+				deleted++
+			}
+		}
+
+		// Deleting a discovery rule deletes all the items and triggers it discovered
+		for _, discoveryRule := range host.DiscoveryRules {
+			if discoveryRule.State != StateNew {
+				existing++
+			}
+			if discoveryRule.State == StateOld {
+				deleted++
+			}
+		}
+	}
+
+	return
+}
+
+// Abort the cycle if it would delete more objects than allowed, unless mass deletion is explicitly allowed
+func (p *Provisioner) CheckDeletions() error {
+
+	deleted, err := p.GuardDeletions()
+	PlannedDeletions.Set(float64(deleted))
+
+	if err != nil {
+		DeletionGuardTriggered.Inc()
+		log.Errorf("DELETION GUARD: %s, nothing was changed in Zabbix. Check your rule sources, or run with -allow-mass-deletion if that's intended", err)
+		return err
+	}
+
+	return nil
+}
+
+// Count the objects the cycle would delete, with an error when that's more than allowed
+// The managed items and triggers of the hosts to prune are counted too, hosts dropped from the configuration
+// by a rule source returning no rules would otherwise delete them all
+func (p *Provisioner) GuardDeletions() (int, error) {
+
+	existing, deleted := p.CountDeletions()
+	existing += p.PruneObjects
+	deleted += p.PruneObjects
+
+	if deleted == 0 || p.AllowMassDeletion {
+		return deleted, nil
+	}
+
+	guard := p.Config.DeletionGuard
+
+	// Nothing left is what a rule source returning no rules looks like, even on a small setup
+	if deleted >= existing {
+		return deleted, fmt.Errorf("the cycle would delete all the %d managed items and triggers", deleted)
+	}
+	if guard.MaxDeletions > 0 && deleted > guard.MaxDeletions {
+		return deleted, fmt.Errorf("the cycle would delete %d items and triggers, more than the %d allowed", deleted, guard.MaxDeletions)
+	}
+	if guard.MaxDeletionPercent > 0 && deleted > guard.MinDeletions && deleted*100 > guard.MaxDeletionPercent*existing {
+		return deleted, fmt.Errorf("the cycle would delete %d of the %d managed items and triggers, more than the %d%% allowed", deleted, existing, guard.MaxDeletionPercent)
+	}
+
+	return deleted, nil
+}
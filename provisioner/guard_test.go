@@ -0,0 +1,113 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"testing"
+)
+
+// Build a Zabbix state with a host holding existing managed items, some of them to be deleted
+func newGuardZabbix(existing int, deleted int) *CustomZabbix {
+
+	z := NewCustomZabbix()
+	host := z.AddHost(&CustomHost{
+		State:           StateEqual,
+		Host:            zabbix.Host{Host: "host", Name: "host"},
+		Items:           map[string]*CustomItem{},
+		Triggers:        map[string]*CustomTrigger{},
+		Templates:       map[string]struct{}{},
+		LinkedTemplates: map[string]string{},
+	})
+
+	for i := 0; i < existing; i++ {
+		state := StateEqual
+		if i < deleted {
+			state = StateOld
+		}
+		key := "prometheus.rule" + string(rune('a'+i))
+		host.Items[key] = &CustomItem{State: state, Item: zabbix.Item{Key: key}}
+	}
+
+	return z
+}
+
+func TestCheckDeletions(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		guard    DeletionGuardConfig
+		existing int
+		deleted  int
+		allow    bool
+		fail     bool
+	}{
+		{name: "nothing deleted", guard: DeletionGuardConfig{MaxDeletionPercent: 50}, existing: 6},
+		{name: "under the percentage", guard: DeletionGuardConfig{MaxDeletionPercent: 50}, existing: 20, deleted: 10},
+		{name: "over the percentage", guard: DeletionGuardConfig{MaxDeletionPercent: 50}, existing: 20, deleted: 11, fail: true},
+		{name: "small setup under the floor", guard: DeletionGuardConfig{MaxDeletionPercent: 50, MinDeletions: 10}, existing: 6, deleted: 4},
+		{name: "small setup deleting everything", guard: DeletionGuardConfig{MaxDeletionPercent: 50, MinDeletions: 10}, existing: 6, deleted: 6, fail: true},
+		{name: "no limits deleting everything", existing: 6, deleted: 6, fail: true},
+		{name: "everything deleted allowed", existing: 6, deleted: 6, allow: true},
+		{name: "over the floor", guard: DeletionGuardConfig{MaxDeletionPercent: 50, MinDeletions: 10}, existing: 20, deleted: 11, fail: true},
+		{name: "over the maximum", guard: DeletionGuardConfig{MaxDeletions: 2}, existing: 20, deleted: 3, fail: true},
+		{name: "mass deletion allowed", guard: DeletionGuardConfig{MaxDeletions: 2}, existing: 20, deleted: 3, allow: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{
+				Config:            ProvisionerConfig{DeletionGuard: test.guard},
+				CustomZabbix:      newGuardZabbix(test.existing, test.deleted),
+				AllowMassDeletion: test.allow,
+			}
+
+			err := p.CheckDeletions()
+			if test.fail && err == nil {
+				t.Error("expected the cycle to be aborted")
+			}
+			if !test.fail && err != nil {
+				t.Errorf("expected the cycle to go on, got %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckDeletionsEmptySource(t *testing.T) {
+
+	p := &Provisioner{
+		Config: ProvisionerConfig{
+			ZabbixKeyPrefix: "prometheus",
+			ZabbixHosts:     []HostConfig{{Name: "all", MatchAll: true}},
+			DeletionGuard:   DeletionGuardConfig{MaxDeletionPercent: 50, MinDeletions: 10},
+		},
+		RuleSources:  []RuleSource{&namedRuleSource{name: "prod"}},
+		CustomZabbix: NewCustomZabbix(),
+	}
+
+	err := p.FillFromPrometheus()
+	if err != nil {
+		t.Fatalf("FillFromPrometheus: %s", err)
+	}
+
+	// The few items and triggers of the rules the source returned before are in Zabbix
+	host := p.Hosts["all"]
+	host.AddItem(&CustomItem{State: StateOld, Item: zabbix.Item{Key: "prometheus.nodedown"}})
+	host.AddTrigger(&CustomTrigger{State: StateOld, Trigger: zabbix.Trigger{Expression: "{all:prometheus.nodedown.last()}<>0"}})
+
+	if err := p.CheckDeletions(); err == nil {
+		t.Error("expected the cycle deleting everything to be aborted")
+	}
+}
+
+func TestCountDeletionsTemplateUnlink(t *testing.T) {
+
+	z := newGuardZabbix(2, 0)
+	host := z.Hosts["host"]
+	host.LinkedTemplates["Template Prometheus"] = "10"
+	z.ManagedTemplates["Template Prometheus"] = struct{}{}
+	z.TemplateObjects["Template Prometheus"] = 8
+
+	existing, deleted := z.CountDeletions()
+	if existing != 10 || deleted != 8 {
+		t.Errorf("expected 8 of 10 objects deleted, got %d of %d", deleted, existing)
+	}
+}
@@ -0,0 +1,208 @@
+package provisioner
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Characters Zabbix rejects in host names
+var hostNameInvalidCharacters = regexp.MustCompile(`[^0-9A-Za-z _.-]+`)
+
+// Generate one host per distinct value of a rule label or annotation
+// Name, hostGroups, tag, deploymentStatus, zabbixTemplate and itemDefault* are Go templates rendered with HostTemplateData
+type HostTemplateConfig struct {
+	HostConfig     `yaml:",inline"`
+	FromLabel      string `yaml:"fromLabel"`
+	FromAnnotation string `yaml:"fromAnnotation"`
+}
+
+// Values available in host templates, taken from the first rule giving a host
+type HostTemplateData struct {
+	Value       string
+	Source      string
+	Tenant      string
+	Namespace   string
+	Group       string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Check that a host template is usable
+func (t *HostTemplateConfig) Validate() error {
+
+	if (len(t.FromLabel) == 0) == (len(t.FromAnnotation) == 0) {
+		return fmt.Errorf("host template %s: exactly one of fromLabel or fromAnnotation must be set", t.Name)
+	}
+
+	if err := t.HostConfig.Validate(); err != nil {
+		return fmt.Errorf("host template %s: %s", t.Name, err)
+	}
+
+	// Render with empty values to catch syntax errors early
+	_, err := t.Render(HostTemplateData{})
+	return err
+}
+
+// Get the value giving the host name for a rule
+func (t *HostTemplateConfig) ValueOf(rule PrometheusRule) (string, bool) {
+
+	var value string
+	var ok bool
+
+	if len(t.FromLabel) != 0 {
+		value, ok = rule.Labels[t.FromLabel]
+	} else {
+		value, ok = rule.Annotations[t.FromAnnotation]
+	}
+
+	return value, ok && len(value) != 0
+}
+
+// Render the host configuration for a value, the host selects the rules of the template having that value
+func (t *HostTemplateConfig) Render(data HostTemplateData) (HostConfig, error) {
+
+	hostConfig := t.HostConfig
+
+	// The host only selects rules having the value it was created for
+	valueMatcher := Matcher{Key: t.FromLabel, Operator: MatchEqual, Value: data.Value}
+	if len(t.FromLabel) != 0 {
+		hostConfig.LabelMatchers = append(append([]Matcher{}, t.LabelMatchers...), valueMatcher)
+	} else {
+		valueMatcher.Key = t.FromAnnotation
+		hostConfig.AnnotationMatchers = append(append([]Matcher{}, t.AnnotationMatchers...), valueMatcher)
+	}
+
+	nameTemplate := t.Name
+	if len(nameTemplate) == 0 {
+		nameTemplate = "{{ .Value }}"
+	}
+
+	fields := []*string{
+		&hostConfig.Tag,
+		&hostConfig.DeploymentStatus,
+		&hostConfig.ZabbixTemplate,
+		&hostConfig.ItemDefaultApplication,
+		&hostConfig.ItemDefaultHistory,
+		&hostConfig.ItemDefaultTrends,
+		&hostConfig.ItemDefaultTrapperHosts,
+	}
+
+	name, err := renderTemplate(nameTemplate, data)
+	if err != nil {
+		return hostConfig, fmt.Errorf("host template %s: %s", t.Name, err)
+	}
+	hostConfig.Name = SanitizeHostName(name)
+	if hostConfig.Name != name {
+		log.Debugf("Host template %s gives the name %q, using %q", t.Name, name, hostConfig.Name)
+	}
+
+	for _, field := range fields {
+		*field, err = renderTemplate(*field, data)
+		if err != nil {
+			return hostConfig, fmt.Errorf("host template %s: %s", t.Name, err)
+		}
+	}
+
+	hostConfig.HostGroups = make([]string, 0, len(t.HostGroups))
+	for _, hostGroup := range t.HostGroups {
+		rendered, err := renderTemplate(hostGroup, data)
+		if err != nil {
+			return hostConfig, fmt.Errorf("host template %s: %s", t.Name, err)
+		}
+		if len(rendered) != 0 {
+			hostConfig.HostGroups = append(hostConfig.HostGroups, rendered)
+		}
+	}
+
+	return hostConfig, nil
+}
+
+// Replace the characters Zabbix rejects in host names, label values often have "/" or ":"
+func SanitizeHostName(name string) string {
+	return strings.TrimSpace(hostNameInvalidCharacters.ReplaceAllString(name, "_"))
+}
+
+func renderTemplate(text string, data interface{}) (string, error) {
+
+	tmpl, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// Get the configured hosts along with the hosts generated from templates for those rules
+func (p *Provisioner) GetHostConfigs(rules []PrometheusRule) ([]HostConfig, error) {
+
+	hostConfigs := append([]HostConfig{}, p.Config.ZabbixHosts...)
+
+	hostNames := make(map[string]struct{}, len(hostConfigs))
+	for _, hostConfig := range hostConfigs {
+		hostNames[hostConfig.Name] = struct{}{}
+	}
+
+	for _, hostTemplate := range p.Config.HostTemplates {
+
+		// The label or annotation giving the host name is enough to select rules
+		selectorConfig := hostTemplate.HostConfig
+		selectorConfig.MatchAll = true
+
+		values := map[string]struct{}{}
+		for _, rule := range rules {
+
+			value, ok := hostTemplate.ValueOf(rule)
+			if !ok {
+				continue
+			}
+
+			if _, ok := values[value]; ok {
+				continue
+			}
+
+			if !p.IsMatching(selectorConfig, rule) {
+				continue
+			}
+			values[value] = struct{}{}
+
+			hostConfig, err := hostTemplate.Render(HostTemplateData{
+				Value:       value,
+				Source:      rule.Source,
+				Tenant:      rule.Tenant,
+				Namespace:   rule.Namespace,
+				Group:       rule.Group,
+				Labels:      rule.Labels,
+				Annotations: rule.Annotations,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if len(hostConfig.Name) == 0 {
+				log.Warnf("Host template %s gives an empty name for value %s, skipping", hostTemplate.Name, value)
+				continue
+			}
+
+			if _, ok := hostNames[hostConfig.Name]; ok {
+				log.Warnf("Host %s from template %s is already declared, skipping", hostConfig.Name, hostTemplate.Name)
+				continue
+			}
+			hostNames[hostConfig.Name] = struct{}{}
+
+			log.Debugf("Host from template %s: %+v", hostTemplate.Name, hostConfig)
+			hostConfigs = append(hostConfigs, hostConfig)
+		}
+	}
+
+	return hostConfigs, nil
+}
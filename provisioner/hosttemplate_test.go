@@ -0,0 +1,140 @@
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetHostConfigs(t *testing.T) {
+
+	rules := []PrometheusRule{
+		{Name: "NodeDown", Labels: map[string]string{"cluster": "eu/west", "team": "infra"}},
+		{Name: "PodCrashLooping", Labels: map[string]string{"cluster": "eu/west", "team": "apps"}},
+		{Name: "NodeDown", Labels: map[string]string{"cluster": "us", "team": "infra"}},
+		{Name: "DiskFull", Labels: map[string]string{"cluster": "ap", "team": "storage"}},
+		{Name: "Watchdog", Labels: map[string]string{}},
+	}
+
+	p := &Provisioner{Config: ProvisionerConfig{
+		ZabbixHosts: []HostConfig{{Name: "k8s-us", MatchAll: true}},
+		HostTemplates: []HostTemplateConfig{{
+			HostConfig: HostConfig{
+				Name:       "k8s-{{ .Value }}",
+				HostGroups: []string{"Clusters", "{{ .Labels.team }}"},
+				Tag:        "{{ .Labels.team }}",
+				LabelMatchers: []Matcher{
+					{Key: "team", Operator: MatchNotEqual, Value: "storage"},
+				},
+			},
+			FromLabel: "cluster",
+		}},
+	}}
+
+	hostConfigs, err := p.GetHostConfigs(rules)
+	if err != nil {
+		t.Fatalf("GetHostConfigs: %s", err)
+	}
+
+	// k8s-us is already declared, the ap cluster is excluded by the matchers, the first rule of a value gives the host
+	if len(hostConfigs) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", hostConfigs)
+	}
+	if hostConfigs[0].Name != "k8s-us" {
+		t.Errorf("expected the declared host first, got %s", hostConfigs[0].Name)
+	}
+
+	generated := hostConfigs[1]
+	if generated.Name != "k8s-eu_west" {
+		t.Errorf("expected a sanitized name, got %q", generated.Name)
+	}
+	if !reflect.DeepEqual(generated.HostGroups, []string{"Clusters", "infra"}) || generated.Tag != "infra" {
+		t.Errorf("expected the fields of the first rule, got groups %v and tag %q", generated.HostGroups, generated.Tag)
+	}
+
+	// The generated host selects the rules of its value only
+	selected := []string{}
+	for _, rule := range rules {
+		if p.IsMatching(generated, rule) {
+			selected = append(selected, rule.Name)
+		}
+	}
+	if !reflect.DeepEqual(selected, []string{"NodeDown", "PodCrashLooping"}) {
+		t.Errorf("expected the rules of eu/west, got %v", selected)
+	}
+
+	// The template itself is left untouched
+	if len(p.Config.HostTemplates[0].LabelMatchers) != 1 {
+		t.Errorf("expected the template matchers to be kept, got %+v", p.Config.HostTemplates[0].LabelMatchers)
+	}
+}
+
+func TestGetHostConfigsFromAnnotation(t *testing.T) {
+
+	rules := []PrometheusRule{
+		{Name: "NodeDown", Annotations: map[string]string{"zabbix_host": "db01"}},
+		{Name: "DiskFull", Annotations: map[string]string{"zabbix_host": ""}},
+	}
+
+	p := &Provisioner{Config: ProvisionerConfig{
+		HostTemplates: []HostTemplateConfig{{FromAnnotation: "zabbix_host"}},
+	}}
+
+	hostConfigs, err := p.GetHostConfigs(rules)
+	if err != nil {
+		t.Fatalf("GetHostConfigs: %s", err)
+	}
+
+	// The name defaults to the value, empty values give no host
+	if len(hostConfigs) != 1 || hostConfigs[0].Name != "db01" {
+		t.Fatalf("expected a single host db01, got %+v", hostConfigs)
+	}
+	if !p.IsMatching(hostConfigs[0], rules[0]) || p.IsMatching(hostConfigs[0], rules[1]) {
+		t.Error("expected the host to select the rules of its annotation value only")
+	}
+}
+
+func TestHostTemplateValidate(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		template HostTemplateConfig
+		valid    bool
+	}{
+		{name: "from label", template: HostTemplateConfig{FromLabel: "cluster"}, valid: true},
+		{name: "from annotation", template: HostTemplateConfig{FromAnnotation: "zabbix_host"}, valid: true},
+		{name: "no source", template: HostTemplateConfig{}, valid: false},
+		{name: "both sources", template: HostTemplateConfig{FromLabel: "cluster", FromAnnotation: "zabbix_host"}, valid: false},
+		{name: "invalid name", template: HostTemplateConfig{HostConfig: HostConfig{Name: "{{ .Value"}, FromLabel: "cluster"}, valid: false},
+		{name: "invalid host group", template: HostTemplateConfig{HostConfig: HostConfig{HostGroups: []string{"{{ end }}"}}, FromLabel: "cluster"}, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.template.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSanitizeHostName(t *testing.T) {
+
+	tests := map[string]string{
+		"db01":                "db01",
+		"eu/west":             "eu_west",
+		"namespace:pod":       "namespace_pod",
+		" cluster.local ":     "cluster.local",
+		"team a/b::c":         "team a_b_c",
+		"already_sane-name.1": "already_sane-name.1",
+	}
+
+	for name, expected := range tests {
+		if sanitized := SanitizeHostName(name); sanitized != expected {
+			t.Errorf("%q: expected %q, got %q", name, expected, sanitized)
+		}
+	}
+}
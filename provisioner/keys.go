@@ -0,0 +1,100 @@
+package provisioner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Item keys are zabbixKeyPrefix.<rendered key template>, the rule name by default
+const DefaultKeyTemplate = "{{ .Name }}"
+
+// Characters Zabbix accepts in an item key, outside of its parameters
+var keyInvalidCharacters = regexp.MustCompile(`[^0-9a-z_.-]+`)
+
+// Values available in key templates
+type KeyTemplateData struct {
+	Name      string
+	Source    string
+	Tenant    string
+	Namespace string
+	Group     string
+	Labels    map[string]string
+}
+
+// Lowercase a key and replace the characters Zabbix rejects
+func SanitizeKey(key string) string {
+	return strings.Trim(keyInvalidCharacters.ReplaceAllString(strings.ToLower(key), "_"), ".")
+}
+
+// Check that the key prefix is a valid key on its own
+func ValidateKeyPrefix(prefix string) error {
+
+	if len(prefix) == 0 {
+		return fmt.Errorf("zabbixKeyPrefix can't be empty, it tells the items managed by the provisioner")
+	}
+
+	if SanitizeKey(prefix) != prefix {
+		return fmt.Errorf("zabbixKeyPrefix %q has characters not allowed in item keys, use %q", prefix, SanitizeKey(prefix))
+	}
+
+	return nil
+}
+
+// Render a key template with empty values to catch syntax errors early
+func ValidateKeyTemplate(keyTemplate string) error {
+	_, err := renderTemplate(keyTemplate, KeyTemplateData{})
+	if err != nil {
+		return fmt.Errorf("key template: %s", err)
+	}
+	return nil
+}
+
+// Prefix of the keys of all the items managed by the provisioner
+func (p *Provisioner) KeyPrefix() string {
+	return p.Config.ZabbixKeyPrefix + "."
+}
+
+// Get the item key of a rule on a host, from the key template of the host
+func (p *Provisioner) GetItemKey(hostConfig HostConfig, rule PrometheusRule) (string, error) {
+
+	keyTemplate := hostConfig.KeyTemplate
+	if len(keyTemplate) == 0 {
+		keyTemplate = DefaultKeyTemplate
+	}
+
+	key, err := renderTemplate(keyTemplate, KeyTemplateData{
+		Name:      rule.Name,
+		Source:    rule.Source,
+		Tenant:    rule.Tenant,
+		Namespace: rule.Namespace,
+		Group:     rule.Group,
+		Labels:    rule.Labels,
+	})
+	if err != nil {
+		return "", fmt.Errorf("key template of host %s: %s", hostConfig.Name, err)
+	}
+
+	key = SanitizeKey(key)
+	if len(key) == 0 {
+		return "", fmt.Errorf("key template of host %s gives an empty key for rule %s", hostConfig.Name, rule.Name)
+	}
+
+	return p.KeyPrefix() + key, nil
+}
+
+// Describe a rule well enough to find it in the rule sources
+func DescribeRule(rule PrometheusRule) string {
+
+	parts := []string{}
+	for _, part := range []string{rule.Source, rule.Tenant, rule.Namespace, rule.Group} {
+		if len(part) != 0 {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return rule.Name
+	}
+	return fmt.Sprintf("%s (%s)", rule.Name, strings.Join(parts, "/"))
+}
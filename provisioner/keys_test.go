@@ -0,0 +1,149 @@
+package provisioner
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+func TestSanitizeKey(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{name: "valid", key: "node_down", expected: "node_down"},
+		{name: "lower case", key: "NodeDown", expected: "nodedown"},
+		{name: "spaces", key: "node down", expected: "node_down"},
+		{name: "runs of invalid characters", key: "node / down", expected: "node_down"},
+		{name: "dots and dashes kept", key: "infra.node-down", expected: "infra.node-down"},
+		{name: "leading and trailing dots", key: ".node.", expected: "node"},
+		{name: "brackets", key: "node[down]", expected: "node_down_"},
+		{name: "empty", key: "", expected: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if key := SanitizeKey(test.key); key != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, key)
+			}
+		})
+	}
+}
+
+func TestGetItemKey(t *testing.T) {
+
+	rule := PrometheusRule{
+		Name:      "NodeDown",
+		Source:    "prometheus",
+		Tenant:    "team-a",
+		Namespace: "monitoring",
+		Group:     "nodes",
+		Labels:    map[string]string{"severity": "critical"},
+	}
+
+	tests := []struct {
+		name        string
+		keyTemplate string
+		expected    string
+		valid       bool
+	}{
+		{name: "default template", expected: "prometheus.nodedown", valid: true},
+		{name: "tenant and name", keyTemplate: "{{ .Tenant }}.{{ .Name }}", expected: "prometheus.team-a.nodedown", valid: true},
+		{name: "namespace and group", keyTemplate: "{{ .Namespace }}/{{ .Group }}/{{ .Name }}", expected: "prometheus.monitoring_nodes_nodedown", valid: true},
+		{name: "label", keyTemplate: "{{ .Name }}.{{ .Labels.severity }}", expected: "prometheus.nodedown.critical", valid: true},
+		{name: "missing label", keyTemplate: "{{ .Name }}.{{ .Labels.team }}", expected: "prometheus.nodedown", valid: true},
+		{name: "empty key", keyTemplate: "{{ .Labels.team }}", valid: false},
+		{name: "invalid template", keyTemplate: "{{ .Name", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{Config: ProvisionerConfig{ZabbixKeyPrefix: "prometheus"}}
+
+			key, err := p.GetItemKey(HostConfig{Name: "host", KeyTemplate: test.keyTemplate}, rule)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected an error, got key %q", key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if key != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, key)
+			}
+		})
+	}
+}
+
+func TestKeyCollisions(t *testing.T) {
+
+	rules := &namedRuleSource{name: "static", rules: []PrometheusRule{
+		{Name: "NodeDown", Tenant: "team-a", Annotations: map[string]string{"description": "first"}},
+		{Name: "NodeDown", Tenant: "team-b", Annotations: map[string]string{"description": "second"}},
+		{Name: "Node Down", Tenant: "team-a", Annotations: map[string]string{"description": "third"}},
+		{Name: "DiskFull", Tenant: "team-a", Annotations: map[string]string{"description": "fourth"}},
+	}}
+
+	tests := []struct {
+		name        string
+		keyTemplate string
+		collisions  float64
+		expected    map[string]string
+	}{
+		{name: "default template", collisions: 1, expected: map[string]string{
+			"prometheus.nodedown":  "first",
+			"prometheus.node_down": "third",
+			"prometheus.diskfull":  "fourth",
+		}},
+		{name: "tenant in the key", keyTemplate: "{{ .Tenant }}.{{ .Name }}", collisions: 0, expected: map[string]string{
+			"prometheus.team-a.nodedown":  "first",
+			"prometheus.team-b.nodedown":  "second",
+			"prometheus.team-a.node_down": "third",
+			"prometheus.team-a.diskfull":  "fourth",
+		}},
+		{name: "colliding template", keyTemplate: "{{ .Tenant }}", collisions: 2, expected: map[string]string{
+			"prometheus.team-a": "first",
+			"prometheus.team-b": "second",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{
+				Config: ProvisionerConfig{
+					ZabbixKeyPrefix: "prometheus",
+					ZabbixHosts:     []HostConfig{{Name: "host", MatchAll: true, KeyTemplate: test.keyTemplate}},
+				},
+				RuleSources:  []RuleSource{rules},
+				CustomZabbix: NewCustomZabbix(),
+			}
+
+			err := p.FillFromPrometheus()
+			if err != nil {
+				t.Fatalf("FillFromPrometheus: %s", err)
+			}
+
+			if collisions := testutil.ToFloat64(KeyCollisions); collisions != test.collisions {
+				t.Errorf("expected %v collisions, got %v", test.collisions, collisions)
+			}
+
+			// The first rule giving a key wins
+			items := p.Hosts["host"].Items
+			if len(items) != len(test.expected) {
+				t.Fatalf("expected %d items, got %d", len(test.expected), len(items))
+			}
+			for key, description := range test.expected {
+				item, ok := items[key]
+				if !ok {
+					t.Fatalf("missing item %s", key)
+				}
+				if withoutDesired(item.Description) != description {
+					t.Errorf("expected item %s from the %s rule, got the %s one", key, description, withoutDesired(item.Description))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"time"
+)
+
+// prometheus-operator PrometheusRule custom resources
+var PrometheusRuleResource = schema.GroupVersionResource{
+	Group:    "monitoring.coreos.com",
+	Version:  "v1",
+	Resource: "prometheusrules",
+}
+
+// Rules read from PrometheusRule objects in a Kubernetes cluster
+type KubernetesRuleSource struct {
+	SourceName    string
+	Client        dynamic.Interface
+	Namespace     string
+	LabelSelector string
+	RetryInterval time.Duration
+}
+
+func NewKubernetesRuleSource(name string, client dynamic.Interface, namespace string, labelSelector string) *KubernetesRuleSource {
+	return &KubernetesRuleSource{
+		SourceName:    name,
+		Client:        client,
+		Namespace:     namespace,
+		LabelSelector: labelSelector,
+		RetryInterval: 10 * time.Second,
+	}
+}
+
+// Create a Kubernetes client from a kubeconfig file, or from the service account when running in a pod
+func NewKubernetesClient(kubeconfig string) (dynamic.Interface, error) {
+
+	var config *rest.Config
+	var err error
+
+	if len(kubeconfig) != 0 {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("can't load Kubernetes configuration: %s", err)
+	}
+
+	return dynamic.NewForConfig(config)
+}
+
+func (s *KubernetesRuleSource) Name() string {
+	return s.SourceName
+}
+
+func (s *KubernetesRuleSource) GetRules() ([]PrometheusRule, error) {
+
+	list, err := s.resource().List(context.Background(), metav1.ListOptions{
+		LabelSelector: s.LabelSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list PrometheusRule objects: %s", err)
+	}
+
+	rules := []PrometheusRule{}
+	for _, item := range list.Items {
+		itemRules, err := GetRulesFromObject(&item)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, itemRules...)
+	}
+
+	return rules, nil
+}
+
+// Watch PrometheusRule objects and notify on every change, the watch is restarted when it ends or fails
+func (s *KubernetesRuleSource) Watch(changed chan<- struct{}, stop <-chan struct{}) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	resourceVersion := ""
+	for {
+		// Start from the current state to not get an event for every existing object
+		if len(resourceVersion) == 0 {
+			list, err := s.resource().List(ctx, metav1.ListOptions{LabelSelector: s.LabelSelector})
+			if err == nil {
+				resourceVersion = list.GetResourceVersion()
+			} else {
+				log.Warnf("Can't list PrometheusRule objects for source %s: %s", s.SourceName, err)
+			}
+		}
+
+		if len(resourceVersion) != 0 {
+			resourceVersion = s.watch(ctx, resourceVersion, changed)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.RetryInterval):
+		}
+	}
+}
+
+// Forward the events of a single watch, return the last resource version seen or an empty one if it expired
+func (s *KubernetesRuleSource) watch(ctx context.Context, resourceVersion string, changed chan<- struct{}) string {
+
+	watcher, err := s.resource().Watch(ctx, metav1.ListOptions{
+		LabelSelector:   s.LabelSelector,
+		ResourceVersion: resourceVersion,
+	})
+	if err != nil {
+		log.Warnf("Can't watch PrometheusRule objects for source %s: %s", s.SourceName, err)
+		return resourceVersion
+	}
+	defer watcher.Stop()
+
+	for {
+		var event watch.Event
+		var ok bool
+
+		// Don't rely on the watcher to end when stopped
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+		case event, ok = <-watcher.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+		}
+
+		switch event.Type {
+		case watch.Error:
+			// Most likely our resource version is too old, we may have missed some changes
+			log.Warnf("Watch of PrometheusRule objects for source %s failed: %v", s.SourceName, event.Object)
+			Notify(changed)
+			return ""
+		case watch.Added, watch.Modified, watch.Deleted:
+			if object, ok := event.Object.(*unstructured.Unstructured); ok {
+				resourceVersion = object.GetResourceVersion()
+				log.Infof("PrometheusRule %s/%s %s", object.GetNamespace(), object.GetName(), event.Type)
+			}
+			Notify(changed)
+		}
+	}
+}
+
+func (s *KubernetesRuleSource) resource() dynamic.ResourceInterface {
+	if len(s.Namespace) == 0 {
+		return s.Client.Resource(PrometheusRuleResource)
+	}
+	return s.Client.Resource(PrometheusRuleResource).Namespace(s.Namespace)
+}
+
+// Get alerting rules from a PrometheusRule object
+func GetRulesFromObject(object *unstructured.Unstructured) ([]PrometheusRule, error) {
+
+	spec, ok := object.Object["spec"]
+	if !ok {
+		return []PrometheusRule{}, nil
+	}
+
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("PrometheusRule %s/%s: %s", object.GetNamespace(), object.GetName(), err)
+	}
+
+	ruleGroups := RuleGroupsFile{}
+	err = json.Unmarshal(raw, &ruleGroups)
+	if err != nil {
+		return nil, fmt.Errorf("PrometheusRule %s/%s: %s", object.GetNamespace(), object.GetName(), err)
+	}
+
+	rules := []PrometheusRule{}
+	for _, group := range ruleGroups.Groups {
+		groupRules, err := group.AlertingRules()
+		if err != nil {
+			return nil, fmt.Errorf("PrometheusRule %s/%s: %s", object.GetNamespace(), object.GetName(), err)
+		}
+
+		for i := range groupRules {
+			groupRules[i].Namespace = object.GetNamespace()
+		}
+		rules = append(rules, groupRules...)
+	}
+
+	return rules, nil
+}
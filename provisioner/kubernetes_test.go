@@ -0,0 +1,210 @@
+package provisioner
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"testing"
+	"time"
+)
+
+func newPrometheusRule(namespace string, name string, labels map[string]string, rules ...interface{}) *unstructured.Unstructured {
+
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "PrometheusRule",
+		"metadata": map[string]interface{}{
+			"namespace": namespace,
+			"name":      name,
+		},
+		"spec": map[string]interface{}{
+			"groups": []interface{}{
+				map[string]interface{}{
+					"name":  name,
+					"rules": rules,
+				},
+			},
+		},
+	}}
+	object.SetLabels(labels)
+
+	return object
+}
+
+func newFakeKubernetesClient(objects ...runtime.Object) *fake.FakeDynamicClient {
+
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{PrometheusRuleResource: "PrometheusRuleList"}, objects...)
+
+	// The fake tracker gives no resource version to lists, the API server always does
+	client.PrependReactor("list", PrometheusRuleResource.Resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list, err := client.Tracker().List(PrometheusRuleResource,
+			PrometheusRuleResource.GroupVersion().WithKind("PrometheusRule"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+
+		accessor, err := meta.ListAccessor(list)
+		if err != nil {
+			return true, nil, err
+		}
+		accessor.SetResourceVersion("1")
+
+		return true, list, nil
+	})
+
+	return client
+}
+
+var (
+	nodeDownRule = map[string]interface{}{
+		"alert":       "NodeDown",
+		"expr":        "up == 0",
+		"for":         "5m",
+		"labels":      map[string]interface{}{"severity": "critical"},
+		"annotations": map[string]interface{}{"zabbix": "kubernetes"},
+	}
+	recordingRule = map[string]interface{}{
+		"record": "job:up:sum",
+		"expr":   "sum by (job) (up)",
+	}
+	diskFullRule = map[string]interface{}{
+		"alert": "DiskFull",
+		"expr":  "node_filesystem_avail_bytes == 0",
+	}
+)
+
+func TestKubernetesRuleSourceGetRules(t *testing.T) {
+
+	client := newFakeKubernetesClient(
+		newPrometheusRule("monitoring", "nodes", nil, nodeDownRule, recordingRule),
+		newPrometheusRule("storage", "disks", nil, diskFullRule),
+	)
+
+	rules, err := NewKubernetesRuleSource("kubernetes", client, "", "").GetRules()
+	if err != nil {
+		t.Fatalf("GetRules: %s", err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 alerting rules, got %d: %+v", len(rules), rules)
+	}
+
+	byName := map[string]PrometheusRule{}
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+
+	nodeDown, ok := byName["NodeDown"]
+	if !ok {
+		t.Fatalf("rule NodeDown not found in %+v", rules)
+	}
+	if nodeDown.Namespace != "monitoring" || nodeDown.Group != "nodes" {
+		t.Errorf("NodeDown: expected namespace monitoring and group nodes, got %s and %s", nodeDown.Namespace, nodeDown.Group)
+	}
+	if nodeDown.Duration != 5*time.Minute {
+		t.Errorf("NodeDown: expected a 5m duration, got %s", nodeDown.Duration)
+	}
+	if nodeDown.Labels["severity"] != "critical" || nodeDown.Annotations["zabbix"] != "kubernetes" {
+		t.Errorf("NodeDown: unexpected labels %v or annotations %v", nodeDown.Labels, nodeDown.Annotations)
+	}
+
+	if diskFull, ok := byName["DiskFull"]; !ok || diskFull.Namespace != "storage" {
+		t.Errorf("rule DiskFull not found in namespace storage: %+v", rules)
+	}
+}
+
+func TestKubernetesRuleSourceGetRulesFiltered(t *testing.T) {
+
+	client := newFakeKubernetesClient(
+		newPrometheusRule("monitoring", "nodes", map[string]string{"zabbix": "true"}, nodeDownRule),
+		newPrometheusRule("monitoring", "disks", nil, diskFullRule),
+		newPrometheusRule("storage", "other-disks", map[string]string{"zabbix": "true"}, diskFullRule),
+	)
+
+	rules, err := NewKubernetesRuleSource("kubernetes", client, "monitoring", "zabbix=true").GetRules()
+	if err != nil {
+		t.Fatalf("GetRules: %s", err)
+	}
+
+	if len(rules) != 1 || rules[0].Name != "NodeDown" {
+		t.Fatalf("expected only NodeDown from namespace monitoring, got %+v", rules)
+	}
+}
+
+func TestKubernetesRuleSourceWatch(t *testing.T) {
+
+	client := newFakeKubernetesClient(newPrometheusRule("monitoring", "nodes", nil, nodeDownRule))
+
+	// Hand every watcher of the source to the test, events are pushed into them directly
+	watchers := make(chan *watch.FakeWatcher)
+	client.PrependWatchReactor(PrometheusRuleResource.Resource, func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		watchers <- watcher
+		return true, watcher, nil
+	})
+
+	source := NewKubernetesRuleSource("kubernetes", client, "monitoring", "")
+	source.RetryInterval = 10 * time.Millisecond
+
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		source.Watch(changed, stop)
+		close(done)
+	}()
+
+	watcher := nextWatcher(t, watchers)
+
+	// Objects existing before the watch starts are not notified
+	select {
+	case <-changed:
+		t.Fatal("existing objects must not trigger a change")
+	default:
+	}
+
+	// The fake watcher is unbuffered, Add returns once the source received the event
+	object := newPrometheusRule("monitoring", "disks", nil, diskFullRule)
+	object.SetResourceVersion("2")
+	watcher.Add(object)
+	expectChange(t, changed, "after adding a PrometheusRule")
+
+	// An error restarts the watch from a new list, the changes missed in between are notified
+	watcher.Error(&metav1.Status{Reason: metav1.StatusReasonExpired})
+	expectChange(t, changed, "after a watch error")
+	watcher = nextWatcher(t, watchers)
+
+	watcher.Delete(object)
+	expectChange(t, changed, "after deleting a PrometheusRule")
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after stop")
+	}
+}
+
+func nextWatcher(t *testing.T, watchers <-chan *watch.FakeWatcher) *watch.FakeWatcher {
+	select {
+	case watcher := <-watchers:
+		return watcher
+	case <-time.After(5 * time.Second):
+		t.Fatal("the source never started watching")
+		return nil
+	}
+}
+
+func expectChange(t *testing.T, changed <-chan struct{}, when string) {
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no change notified %s", when)
+	}
+}
@@ -0,0 +1,120 @@
+package provisioner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "zabbix_provisioner"
+
+var (
+	DeletionGuardTriggered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deletion_guard_triggered_total",
+		Help:      "Number of cycles aborted because they would have deleted too many objects.",
+	})
+
+	PruneGuardTriggered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "prune_guard_triggered_total",
+		Help:      "Number of cycles that did not prune because they would have deleted too many hosts and host groups.",
+	})
+
+	PlannedDeletions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "planned_deletions",
+		Help:      "Number of managed items and triggers the last cycle planned to delete.",
+	})
+
+	ReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the provisioning cycles.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	})
+
+	ReconcileErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed provisioning cycles.",
+	})
+
+	ReconcileSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_skipped_total",
+		Help:      "Number of provisioning cycles that did not query Zabbix because rules and configuration were unchanged.",
+	})
+
+	LastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful provisioning cycle.",
+	})
+
+	ObjectsChanged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_changed_total",
+		Help:      "Number of Zabbix objects created, updated or deleted, by type.",
+	}, []string{"type", "action"})
+
+	APICalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "zabbix_api_calls_total",
+		Help:      "Number of Zabbix API calls, by method.",
+	}, []string{"method"})
+
+	APIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "zabbix_api_errors_total",
+		Help:      "Number of failed Zabbix API calls, by method.",
+	}, []string{"method"})
+
+	DriftedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "drifted_objects",
+		Help:      "Number of managed items and triggers changed by hand in Zabbix at the last drift check, by type and drift policy.",
+	}, []string{"type", "policy"})
+
+	RulesFetched = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rules_fetched",
+		Help:      "Number of alerting rules read from each rule source in the last cycle.",
+	}, []string{"source"})
+
+	KeyCollisions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "key_collisions",
+		Help:      "Number of rules skipped in the last cycle because another rule of the same host gave the same item key.",
+	})
+
+	RuleSourceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rule_source_errors_total",
+		Help:      "Number of failed reads of each rule source.",
+	}, []string{"source"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		DeletionGuardTriggered,
+		PruneGuardTriggered,
+		PlannedDeletions,
+		ReconcileDuration,
+		ReconcileErrors,
+		ReconcileSkipped,
+		LastSuccess,
+		ObjectsChanged,
+		APICalls,
+		APIErrors,
+		DriftedObjects,
+		RulesFetched,
+		RuleSourceErrors,
+		KeyCollisions,
+	)
+}
+
+// Count the objects a cycle created, updated or deleted
+func CountChanges(objectType string, action string, count int) {
+	if count != 0 {
+		ObjectsChanged.WithLabelValues(objectType, action).Add(float64(count))
+	}
+}
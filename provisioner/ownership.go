@@ -0,0 +1,64 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"regexp"
+	"strings"
+)
+
+var (
+	// {host:key.function(parameters)}, keys and parameters can hold macros like {#INSTANCE} or {$THRESHOLD}
+	triggerFunctionRegexp = regexp.MustCompile(`\{([^{}:]+):((?:[^{}]|\{[#$][^{}]*\})+)\.(\w+)\(((?:[^(){}]|\{[#$][^{}]*\})*)\)\}`)
+	// function(/host/key,parameters)
+	triggerFunctionNewRegexp = regexp.MustCompile(`(\w+)\(/([^/]*)/([^,()\[]+(?:\[[^\]]*\])?)(?:,([^()]*))?\)`)
+	// prefix.name, the shape of the keys of the provisioner
+	provisionedKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+\.[^.]`)
+)
+
+// Check if an item was created by the provisioner, its key starts with the key prefix, anything else on a host is left alone
+func (p *Provisioner) IsOwnedItem(item zabbix.Item) bool {
+	return strings.HasPrefix(item.Key, p.KeyPrefix())
+}
+
+// Check if an item not owned looks like one created by the provisioner with another key prefix:
+// a trapper item of unsigned integers whose key is a prefix, a dot and a name
+func (p *Provisioner) IsForeignPrefixItem(item zabbix.Item) bool {
+
+	if item.Type != zabbix.ZabbixTrapper || item.ValueType != zabbix.Unsigned || p.IsOwnedItem(item) {
+		return false
+	}
+
+	return provisionedKeyRegexp.MatchString(item.Key)
+}
+
+// Check if a trigger was created by the provisioner, meaning all the items it uses are ours
+func (p *Provisioner) IsOwnedTrigger(trigger zabbix.Trigger) bool {
+
+	keys := TriggerItemKeys(trigger.Expression)
+	if len(keys) == 0 {
+		return false
+	}
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, p.KeyPrefix()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the keys of the items used in an expanded trigger expression, with the old or the Zabbix 5.4+ syntax
+func TriggerItemKeys(expression string) []string {
+
+	keys := []string{}
+
+	for _, match := range triggerFunctionRegexp.FindAllStringSubmatch(expression, -1) {
+		keys = append(keys, match[2])
+	}
+
+	for _, match := range triggerFunctionNewRegexp.FindAllStringSubmatch(expression, -1) {
+		keys = append(keys, match[3])
+	}
+
+	return keys
+}
@@ -0,0 +1,148 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsForeignPrefixItem(t *testing.T) {
+
+	p := &Provisioner{Config: ProvisionerConfig{ZabbixKeyPrefix: "alerts"}}
+
+	tests := []struct {
+		name     string
+		item     zabbix.Item
+		expected bool
+	}{
+		{name: "old prefix", item: zabbix.Item{Key: "prometheus.nodedown", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned}, expected: true},
+		{name: "old prefix with a key template", item: zabbix.Item{Key: "prometheus.infra.nodedown", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned}, expected: true},
+		{name: "owned", item: zabbix.Item{Key: "alerts.nodedown", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned}},
+		{name: "agent item", item: zabbix.Item{Key: "system.cpu.load[all,avg1]", Type: 0, ValueType: zabbix.Float}},
+		{name: "text trapper", item: zabbix.Item{Key: "app.status", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Text}},
+		{name: "no prefix", item: zabbix.Item{Key: "nodedown", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if foreign := p.IsForeignPrefixItem(test.item); foreign != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, foreign)
+			}
+		})
+	}
+}
+
+// Zabbix with the host infra holding managed items and triggers next to hand-made ones
+func newOwnershipServer(t *testing.T) *httptest.Server {
+
+	itemApplications := map[string]zabbix.Applications{
+		"1": {{ApplicationId: "1", Name: "Prometheus"}},
+		"2": {{ApplicationId: "1", Name: "Prometheus"}},
+		"3": {{ApplicationId: "2", Name: "Agent"}},
+	}
+
+	return newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+		"hostgroup.get": func(params map[string]interface{}) interface{} {
+			return zabbix.HostGroups{}
+		},
+		"host.get": func(params map[string]interface{}) interface{} {
+			if _, ok := params["selectParentTemplates"]; ok {
+				return HostsTemplates{{HostId: "10", Host: "infra"}}
+			}
+			return zabbix.Hosts{{HostId: "10", Host: "infra", Name: "infra"}}
+		},
+		"item.get": func(params map[string]interface{}) interface{} {
+			return zabbix.Items{
+				{ItemId: "1", HostId: "10", Key: "prometheus.nodedown", Name: "NodeDown", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned},
+				{ItemId: "2", HostId: "10", Key: "prometheus.oldrule", Name: "OldRule", Type: zabbix.ZabbixTrapper, ValueType: zabbix.Unsigned},
+				{ItemId: "3", HostId: "10", Key: "agent.ping", Name: "Agent ping", Type: zabbix.ZabbixAgent, ValueType: zabbix.Unsigned},
+			}
+		},
+		"application.get": func(params map[string]interface{}) interface{} {
+			if itemId, ok := params["itemids"]; ok {
+				return itemApplications[itemId.(string)]
+			}
+			return zabbix.Applications{
+				{ApplicationId: "1", Name: "Prometheus"},
+				{ApplicationId: "2", Name: "Agent"},
+				{ApplicationId: "3", Name: "Manual"},
+			}
+		},
+		"trigger.get": func(params map[string]interface{}) interface{} {
+			if _, ok := params["selectTags"]; ok {
+				return []TriggerTags{}
+			}
+			return zabbix.Triggers{
+				{TriggerId: "1", Expression: "{infra:prometheus.oldrule.last()}<>0"},
+				{TriggerId: "2", Expression: "{infra:agent.ping.nodata(5m)}=1"},
+				{TriggerId: "3", Expression: "{infra:prometheus.nodedown.last()}<>0 and {infra:agent.ping.last()}=0"},
+			}
+		},
+		"discoveryrule.get": func(params map[string]interface{}) interface{} {
+			return []DiscoveryRule{}
+		},
+	})
+}
+
+func TestFillFromZabbixOwnership(t *testing.T) {
+
+	server := newOwnershipServer(t)
+	defer server.Close()
+
+	p := &Provisioner{
+		Api: zabbix.NewAPI(server.URL),
+		Config: ProvisionerConfig{
+			ZabbixKeyPrefix: "prometheus",
+			ZabbixHosts:     []HostConfig{{Name: "infra", MatchAll: true, ItemDefaultApplication: "Prometheus"}},
+		},
+		RuleSources:   []RuleSource{&namedRuleSource{name: "prod", rules: []PrometheusRule{{Name: "NodeDown"}}}},
+		CustomZabbix:  NewCustomZabbix(),
+		ZabbixVersion: &ZabbixVersion{Major: 5, Minor: 2},
+	}
+
+	if err := p.FillFromPrometheus(); err != nil {
+		t.Fatalf("FillFromPrometheus: %s", err)
+	}
+	if err := p.FillFromZabbix(); err != nil {
+		t.Fatalf("FillFromZabbix: %s", err)
+	}
+
+	host := p.Hosts["infra"]
+
+	// Only the managed rule removed from Prometheus is deleted
+	oldItems := []string{}
+	for key, item := range host.Items {
+		if item.State == StateOld {
+			oldItems = append(oldItems, key)
+		}
+	}
+	if !reflect.DeepEqual(oldItems, []string{"prometheus.oldrule"}) {
+		t.Errorf("expected only prometheus.oldrule to be deleted, got %v", oldItems)
+	}
+	if _, ok := host.Items["agent.ping"]; ok {
+		t.Error("expected the hand-made item to be ignored")
+	}
+
+	// Triggers using a hand-made item are not ours, even with one of our items
+	oldTriggers := []string{}
+	for _, trigger := range host.Triggers {
+		if strings.Contains(trigger.Expression, "agent.ping") {
+			t.Errorf("expected the trigger %s to be ignored", trigger.Expression)
+		}
+		if trigger.State == StateOld {
+			oldTriggers = append(oldTriggers, trigger.TriggerId)
+		}
+	}
+	if !reflect.DeepEqual(oldTriggers, []string{"1"}) {
+		t.Errorf("expected only the trigger of prometheus.oldrule to be deleted, got %v", oldTriggers)
+	}
+
+	// Applications used by hand-made items or by none of ours are kept
+	for name, application := range host.Applications {
+		if application.State == StateOld {
+			t.Errorf("expected application %s to be kept", name)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Ordering of object types in a plan
+var planTypes = []string{"hostgroup", "templategroup", "template", "host", "application", "item", "trigger", "discoveryrule", "itemprototype", "triggerprototype", "link"}
+
+// Everything ApplyChanges would do, computed from the states of the objects
+type Plan struct {
+	Changes []Change `json:"changes"`
+	// Why the deletion guard would abort a real cycle, empty when it would go on
+	DeletionGuard string `json:"deletionGuard,omitempty"`
+}
+
+type Change struct {
+	Action string        `json:"action"`
+	Type   string        `json:"type"`
+	Host   string        `json:"host,omitempty"`
+	Name   string        `json:"name"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Build the plan from a CustomZabbix filled from Prometheus and Zabbix
+func (z *CustomZabbix) Plan() *Plan {
+
+	plan := &Plan{Changes: []Change{}}
+
+	for _, hostGroup := range z.HostGroups {
+		if hostGroup.State == StateNew {
+			plan.Add(Change{Action: ActionCreate, Type: "hostgroup", Name: hostGroup.Name})
+		}
+	}
+
+	for _, templateGroup := range z.TemplateGroups {
+		if templateGroup.State == StateNew {
+			plan.Add(Change{Action: ActionCreate, Type: "templategroup", Name: templateGroup.Name})
+		}
+	}
+
+	for _, template := range z.Templates {
+		switch template.State {
+		case StateNew:
+			plan.Add(Change{Action: ActionCreate, Type: "template", Name: template.Name})
+		case StateUpdated:
+			plan.Add(Change{Action: ActionUpdate, Type: "template", Name: template.Name,
+				Fields: []FieldChange{{Field: "description", New: ManagedTemplateDescription}}})
+		}
+	}
+
+	for _, host := range z.Hosts {
+		switch host.State {
+		case StateNew:
+			plan.Add(Change{Action: ActionCreate, Type: "host", Name: host.Name, Fields: DiffHosts(&CustomHost{}, host)})
+		case StateUpdated:
+			plan.Add(Change{Action: ActionUpdate, Type: "host", Name: host.Name, Fields: DiffHosts(host.Current, host)})
+		}
+
+		link, unlink := z.GetTemplateLinks(host)
+		for _, name := range link {
+			plan.Add(Change{Action: ActionCreate, Type: "link", Host: host.Name, Name: name})
+		}
+		for _, name := range unlink {
+			plan.Add(Change{Action: ActionDelete, Type: "link", Host: host.Name, Name: name})
+		}
+	}
+
+	for _, host := range z.HostsAndTemplates() {
+		for _, application := range host.Applications {
+			switch application.State {
+			case StateNew:
+				plan.Add(Change{Action: ActionCreate, Type: "application", Host: host.Name, Name: application.Name})
+			case StateOld:
+				plan.Add(Change{Action: ActionDelete, Type: "application", Host: host.Name, Name: application.Name})
+			}
+		}
+
+		for _, item := range host.Items {
+			switch item.State {
+			case StateNew:
+				plan.Add(Change{Action: ActionCreate, Type: "item", Host: host.Name, Name: item.Key, Fields: DiffItems(&CustomItem{}, item)})
+			case StateUpdated:
+				plan.Add(Change{Action: ActionUpdate, Type: "item", Host: host.Name, Name: item.Key, Fields: DiffItems(item.Current, item)})
+			case StateOld:
+				plan.Add(Change{Action: ActionDelete, Type: "item", Host: host.Name, Name: item.Key})
+			}
+		}
+
+		for _, trigger := range host.Triggers {
+			switch trigger.State {
+			case StateNew:
+				plan.Add(Change{Action: ActionCreate, Type: "trigger", Host: host.Name, Name: trigger.Expression, Fields: DiffTriggers(&CustomTrigger{}, trigger)})
+			case StateUpdated:
+				plan.Add(Change{Action: ActionUpdate, Type: "trigger", Host: host.Name, Name: trigger.Expression, Fields: DiffTriggers(trigger.Current, trigger)})
+			case StateOld:
+				plan.Add(Change{Action: ActionDelete, Type: "trigger", Host: host.Name, Name: trigger.Expression})
+			}
+		}
+
+		for _, discoveryRule := range host.DiscoveryRules {
+			plan.AddDiscoveryRule(host, discoveryRule)
+		}
+	}
+
+	plan.Sort()
+	return plan
+}
+
+func (plan *Plan) Add(change Change) {
+	plan.Changes = append(plan.Changes, change)
+}
+
+// Sort changes by type, host and name to get a stable output
+func (plan *Plan) Sort() {
+
+	typeOrder := make(map[string]int, len(planTypes))
+	for i, planType := range planTypes {
+		typeOrder[planType] = i
+	}
+
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if a.Type != b.Type {
+			return typeOrder[a.Type] < typeOrder[b.Type]
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Count the changes by action
+func (plan *Plan) Count(action string) int {
+	count := 0
+	for _, change := range plan.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (plan *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+func (plan *Plan) WriteText(w io.Writer) error {
+
+	symbols := map[string]string{
+		ActionCreate: "+",
+		ActionUpdate: "~",
+		ActionDelete: "-",
+	}
+
+	for _, change := range plan.Changes {
+		name := change.Name
+		if len(change.Host) != 0 {
+			name = fmt.Sprintf("%s on host %s", change.Name, change.Host)
+		}
+
+		_, err := fmt.Fprintf(w, "%s %s %s\n", symbols[change.Action], change.Type, name)
+		if err != nil {
+			return err
+		}
+
+		for _, field := range change.Fields {
+			if change.Action == ActionCreate {
+				_, err = fmt.Fprintf(w, "    %s: %q\n", field.Field, field.New)
+			} else {
+				_, err = fmt.Fprintf(w, "    %s: %q => %q\n", field.Field, field.Old, field.New)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete\n",
+		plan.Count(ActionCreate), plan.Count(ActionUpdate), plan.Count(ActionDelete))
+	if err != nil || len(plan.DeletionGuard) == 0 {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Deletion guard: %s, the cycle would be aborted without changing anything\n", plan.DeletionGuard)
+	return err
+}
+
+// Field level differences between two hosts, only the fields compared by CustomHost.Equal are considered
+func DiffHosts(old *CustomHost, new *CustomHost) []FieldChange {
+
+	changes := []FieldChange{}
+	changes = appendFieldChange(changes, "name", old.Name, new.Name)
+	changes = appendFieldChange(changes, "hostGroups", joinSet(old.HostGroups), joinSet(new.HostGroups))
+
+	keys := map[string]struct{}{}
+	for key := range old.Inventory {
+		keys[key] = struct{}{}
+	}
+	for key := range new.Inventory {
+		keys[key] = struct{}{}
+	}
+	for _, key := range sortedKeys(keys) {
+		changes = appendFieldChange(changes, "inventory."+key, old.Inventory[key], new.Inventory[key])
+	}
+
+	return changes
+}
+
+// Field level differences between two items, only the fields compared by CustomItem.Equal are considered
+func DiffItems(old *CustomItem, new *CustomItem) []FieldChange {
+
+	changes := []FieldChange{}
+	changes = appendFieldChange(changes, "name", old.Name, new.Name)
+	changes = appendFieldChange(changes, "description", old.Description, new.Description)
+	changes = appendFieldChange(changes, "history", old.History, new.History)
+	changes = appendFieldChange(changes, "trends", old.Trends, new.Trends)
+	changes = appendFieldChange(changes, "trapperHosts", old.TrapperHosts, new.TrapperHosts)
+	changes = appendFieldChange(changes, "applications", joinSet(old.Applications), joinSet(new.Applications))
+	changes = appendFieldChange(changes, "tags", joinTags(old.Tags), joinTags(new.Tags))
+
+	return changes
+}
+
+// Field level differences between two triggers, only the fields compared by CustomTrigger.Equal are considered
+func DiffTriggers(old *CustomTrigger, new *CustomTrigger) []FieldChange {
+
+	changes := []FieldChange{}
+	changes = appendFieldChange(changes, "expression", old.Expression, new.Expression)
+	changes = appendFieldChange(changes, "name", old.Description, new.Description)
+	changes = appendFieldChange(changes, "severity", priorityName(old), priorityName(new))
+	changes = appendFieldChange(changes, "description", old.Comments, new.Comments)
+	changes = appendFieldChange(changes, "tags", joinTags(old.Tags), joinTags(new.Tags))
+
+	return changes
+}
+
+// Format field changes on a single line, for logs
+func FormatFieldChanges(fields []FieldChange) string {
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s: %q => %q", field.Field, field.Old, field.New))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func appendFieldChange(changes []FieldChange, field string, old string, new string) []FieldChange {
+	if old == new {
+		return changes
+	}
+	return append(changes, FieldChange{Field: field, Old: old, New: new})
+}
+
+func priorityName(trigger *CustomTrigger) string {
+	if len(trigger.Expression) == 0 {
+		return ""
+	}
+	return PriorityName[trigger.Priority]
+}
+
+func joinSet(set map[string]struct{}) string {
+	return strings.Join(sortedKeys(set), ",")
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
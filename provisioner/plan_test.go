@@ -0,0 +1,202 @@
+package provisioner
+
+import (
+	"bytes"
+	"github.com/gmauleon/zabbix-client"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffHosts(t *testing.T) {
+
+	host := &CustomHost{
+		Host:       zabbix.Host{Name: "host", Inventory: map[string]string{"tag": "a", "deployment_status": "0"}},
+		HostGroups: map[string]struct{}{"b": {}, "a": {}},
+	}
+
+	tests := []struct {
+		name     string
+		old      *CustomHost
+		expected []FieldChange
+	}{
+		{name: "equal", old: host, expected: []FieldChange{}},
+		{name: "new host", old: &CustomHost{}, expected: []FieldChange{
+			{Field: "name", New: "host"},
+			{Field: "hostGroups", New: "a,b"},
+			{Field: "inventory.deployment_status", New: "0"},
+			{Field: "inventory.tag", New: "a"},
+		}},
+		{name: "other groups and inventory", old: &CustomHost{
+			Host:       zabbix.Host{Name: "host", Inventory: map[string]string{"tag": "b", "location": "x"}},
+			HostGroups: map[string]struct{}{"a": {}},
+		}, expected: []FieldChange{
+			{Field: "hostGroups", Old: "a", New: "a,b"},
+			{Field: "inventory.deployment_status", New: "0"},
+			{Field: "inventory.location", Old: "x"},
+			{Field: "inventory.tag", Old: "b", New: "a"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := DiffHosts(test.old, host); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestDiffItems(t *testing.T) {
+
+	item := &CustomItem{
+		Item:         zabbix.Item{Name: "NodeDown", Key: "prometheus.nodedown", Description: "Node is down", History: "7d", Trends: "30d"},
+		Applications: map[string]struct{}{"Prometheus": {}},
+		Tags:         NewTagSet([]Tag{{Tag: "team", Value: "infra"}, {Tag: "paging"}}),
+	}
+
+	tests := []struct {
+		name     string
+		old      *CustomItem
+		expected []FieldChange
+	}{
+		{name: "equal", old: item, expected: []FieldChange{}},
+		{name: "new item", old: &CustomItem{}, expected: []FieldChange{
+			{Field: "name", New: "NodeDown"},
+			{Field: "description", New: "Node is down"},
+			{Field: "history", New: "7d"},
+			{Field: "trends", New: "30d"},
+			{Field: "applications", New: "Prometheus"},
+			{Field: "tags", New: "paging:,team:infra"},
+		}},
+		{name: "edited by hand", old: &CustomItem{
+			Item:         zabbix.Item{Name: "NodeDown", Key: "prometheus.nodedown", Description: "edited", History: "7d", Trends: "30d", TrapperHosts: "10.0.0.1"},
+			Applications: map[string]struct{}{"Prometheus": {}},
+			Tags:         NewTagSet([]Tag{{Tag: "team", Value: "web"}, {Tag: "paging"}}),
+		}, expected: []FieldChange{
+			{Field: "description", Old: "edited", New: "Node is down"},
+			{Field: "trapperHosts", Old: "10.0.0.1"},
+			{Field: "tags", Old: "paging:,team:web", New: "paging:,team:infra"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := DiffItems(test.old, item); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestDiffTriggers(t *testing.T) {
+
+	trigger := &CustomTrigger{
+		Trigger: zabbix.Trigger{
+			Expression:  "last(/host/prometheus.nodedown)<>0",
+			Description: "NodeDown",
+			Comments:    "Node is down",
+			Priority:    zabbix.High,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		old      *CustomTrigger
+		expected []FieldChange
+	}{
+		{name: "equal", old: trigger, expected: []FieldChange{}},
+		{name: "new trigger", old: &CustomTrigger{}, expected: []FieldChange{
+			{Field: "expression", New: "last(/host/prometheus.nodedown)<>0"},
+			{Field: "name", New: "NodeDown"},
+			{Field: "severity", New: "high"},
+			{Field: "description", New: "Node is down"},
+		}},
+		{name: "other severity and name", old: &CustomTrigger{
+			Trigger: zabbix.Trigger{
+				Expression:  "last(/host/prometheus.nodedown)<>0",
+				Description: "Node down",
+				Comments:    "Node is down",
+				Priority:    zabbix.NotClassified,
+			},
+			Tags: NewTagSet([]Tag{{Tag: "team", Value: "infra"}}),
+		}, expected: []FieldChange{
+			{Field: "name", Old: "Node down", New: "NodeDown"},
+			{Field: "severity", Old: "not classified", New: "high"},
+			{Field: "tags", Old: "team:infra"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := DiffTriggers(test.old, trigger); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, changes)
+			}
+		})
+	}
+}
+
+func TestFormatFieldChanges(t *testing.T) {
+
+	formatted := FormatFieldChanges([]FieldChange{
+		{Field: "name", Old: "a", New: "b"},
+		{Field: "tags", New: "team:infra"},
+	})
+
+	expected := `name: "a" => "b", tags: "" => "team:infra"`
+	if formatted != expected {
+		t.Errorf("expected %s, got %s", expected, formatted)
+	}
+}
+
+func TestPlanWriteText(t *testing.T) {
+
+	plan := &Plan{Changes: []Change{
+		{Action: ActionDelete, Type: "item", Host: "host", Name: "prometheus.b"},
+		{Action: ActionUpdate, Type: "item", Host: "host", Name: "prometheus.a", Fields: []FieldChange{{Field: "history", Old: "7d", New: "30d"}}},
+		{Action: ActionCreate, Type: "host", Name: "host", Fields: []FieldChange{{Field: "name", New: "host"}}},
+	}}
+	plan.Sort()
+
+	var buffer bytes.Buffer
+	if err := plan.WriteText(&buffer); err != nil {
+		t.Fatalf("WriteText: %s", err)
+	}
+
+	expected := strings.Join([]string{
+		`+ host host`,
+		`    name: "host"`,
+		`~ item prometheus.a on host host`,
+		`    history: "7d" => "30d"`,
+		`- item prometheus.b on host host`,
+		``,
+		`Plan: 1 to create, 1 to update, 1 to delete`,
+		``,
+	}, "\n")
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
+
+func TestPlanWriteTextDeletionGuard(t *testing.T) {
+
+	p := &Provisioner{
+		Config:       ProvisionerConfig{DeletionGuard: DeletionGuardConfig{MaxDeletions: 2}},
+		CustomZabbix: newGuardZabbix(4, 3),
+	}
+
+	plan := p.CustomZabbix.Plan()
+	if _, err := p.GuardDeletions(); err != nil {
+		plan.DeletionGuard = err.Error()
+	}
+
+	var buffer bytes.Buffer
+	if err := plan.WriteText(&buffer); err != nil {
+		t.Fatalf("WriteText: %s", err)
+	}
+
+	expected := "Deletion guard: the cycle would delete 3 items and triggers, more than the 2 allowed, the cycle would be aborted without changing anything\n"
+	if !strings.HasSuffix(buffer.String(), expected) {
+		t.Errorf("expected the plan to end with:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
@@ -2,41 +2,154 @@ package provisioner
 
 import (
 	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"strings"
+	"time"
+)
+
+const (
+	RulesTypeAuto = ""
+	RulesTypeAPI  = "api"
+	RulesTypeHTML = "html"
 )
 
 type PrometheusRule struct {
 	Name        string            `json:"name"`
+	Source      string            `json:"source"`
+	Tenant      string            `json:"tenant"`
+	Namespace   string            `json:"namespace"`
+	Group       string            `json:"group"`
+	Query       string            `json:"query"`
+	Duration    time.Duration     `json:"duration"`
+	Health      string            `json:"health"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Structures returned by the Prometheus /api/v1/rules endpoint
+type PrometheusRulesResponse struct {
+	Status    string                      `json:"status"`
+	ErrorType string                      `json:"errorType"`
+	Error     string                      `json:"error"`
+	Data      PrometheusRulesResponseData `json:"data"`
+}
+
+type PrometheusRulesResponseData struct {
+	Groups []PrometheusRuleGroup `json:"groups"`
+}
+
+type PrometheusRuleGroup struct {
+	Name     string              `json:"name"`
+	File     string              `json:"file"`
+	Interval float64             `json:"interval"`
+	Rules    []PrometheusAPIRule `json:"rules"`
+}
+
+type PrometheusAPIRule struct {
+	Type        string            `json:"type"`
+	Name        string            `json:"name"`
+	Query       string            `json:"query"`
+	Duration    float64           `json:"duration"`
+	Labels      map[string]string `json:"labels"`
 	Annotations map[string]string `json:"annotations"`
+	Health      string            `json:"health"`
+	LastError   string            `json:"lastError"`
+	State       string            `json:"state"`
 }
 
-type PrometheusResponse struct {
-	Rules []PrometheusRule `json:"rules"`
+// Return the rules type to use for an url, guessing it from the url path when not explicitly configured
+func GetRulesType(rulesType string, url string) string {
+	if rulesType != RulesTypeAuto {
+		return rulesType
+	}
+
+	if strings.Contains(url, "/api/v1/rules") {
+		return RulesTypeAPI
+	}
+	return RulesTypeHTML
 }
 
-func GetRulesFromJSON() []PrometheusRule {
-	rulesFile, err := ioutil.ReadFile("rules2.json")
+// Get alerting rules from the Prometheus /api/v1/rules endpoint
+func GetRulesFromAPI(client *http.Client, url string) ([]PrometheusRule, error) {
+	resp, err := client.Get(url)
 	if err != nil {
-		log.Fatalf("Can't open the rules file: %s", err)
+		return nil, fmt.Errorf("can't get rules from %s: %s", url, err)
 	}
+	defer resp.Body.Close()
 
-	response := PrometheusResponse{}
-	err = json.Unmarshal(rulesFile, &response)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't get rules from %s: unexpected status %s", url, resp.Status)
+	}
+
+	response := PrometheusRulesResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
-		log.Fatalf("Can't read the rules file: %s", err)
+		return nil, fmt.Errorf("can't decode rules from %s: %s", url, err)
+	}
+
+	if response.Status != "success" {
+		return nil, fmt.Errorf("can't get rules from %s: %s: %s", url, response.ErrorType, response.Error)
+	}
+
+	return response.Rules(), nil
+}
+
+// Convert the groups of an API response into a flat list of alerting rules
+func (r *PrometheusRulesResponse) Rules() []PrometheusRule {
+
+	rules := []PrometheusRule{}
+
+	for _, group := range r.Data.Groups {
+		for _, apiRule := range group.Rules {
+
+			// Recording rules can't be provisioned in Zabbix
+			if apiRule.Type != "alerting" {
+				continue
+			}
+
+			if apiRule.Health == "err" {
+				log.Warnf("Rule %s in group %s is unhealthy: %s", apiRule.Name, group.Name, apiRule.LastError)
+			}
+
+			rule := PrometheusRule{
+				Name:        apiRule.Name,
+				Group:       group.Name,
+				Query:       apiRule.Query,
+				Duration:    time.Duration(apiRule.Duration * float64(time.Second)),
+				Health:      apiRule.Health,
+				Labels:      apiRule.Labels,
+				Annotations: apiRule.Annotations,
+			}
+
+			if rule.Labels == nil {
+				rule.Labels = map[string]string{}
+			}
+			if rule.Annotations == nil {
+				rule.Annotations = map[string]string{}
+			}
+
+			rules = append(rules, rule)
+		}
 	}
 
-	return response.Rules
+	return rules
 }
 
-func GetRulesFromURL(url string) []PrometheusRule {
-	resp, err := http.Get(url)
+// Get alerting rules by scraping the legacy Prometheus HTML /rules page
+// Only names and annotations are available with that method, prefer GetRulesFromAPI when possible
+func GetRulesFromURL(client *http.Client, url string) ([]PrometheusRule, error) {
+	resp, err := client.Get(url)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("can't get rules from %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't get rules from %s: unexpected status %s", url, resp.Status)
 	}
 
 	tokenizer := html.NewTokenizer(resp.Body)
@@ -50,7 +163,10 @@ func GetRulesFromURL(url string) []PrometheusRule {
 		switch tokenType {
 		case html.ErrorToken:
 			//log.Info(rules)
-			return rules
+			if tokenizer.Err() != io.EOF {
+				return nil, fmt.Errorf("can't parse rules from %s: %s", url, tokenizer.Err())
+			}
+			return rules, nil
 		case html.TextToken:
 			str := string(tokenizer.Text())
 			if strings.HasPrefix(str, "ALERT") {
@@ -58,6 +174,7 @@ func GetRulesFromURL(url string) []PrometheusRule {
 				tokenizer.Next()
 				rule = PrometheusRule{
 					Name:        string(tokenizer.Text()),
+					Labels:      map[string]string{},
 					Annotations: map[string]string{},
 				}
 				rules = append(rules, rule)
@@ -0,0 +1,115 @@
+package provisioner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetRulesType(t *testing.T) {
+
+	tests := []struct {
+		rulesType string
+		url       string
+		expected  string
+	}{
+		{url: "http://prometheus:9090/api/v1/rules", expected: RulesTypeAPI},
+		{url: "http://prometheus:9090/rules", expected: RulesTypeHTML},
+		{rulesType: RulesTypeHTML, url: "http://prometheus:9090/api/v1/rules", expected: RulesTypeHTML},
+		{rulesType: RulesTypeAPI, url: "http://proxy/prometheus-rules", expected: RulesTypeAPI},
+	}
+
+	for _, test := range tests {
+		if rulesType := GetRulesType(test.rulesType, test.url); rulesType != test.expected {
+			t.Errorf("%q with type %q: expected %q, got %q", test.url, test.rulesType, test.expected, rulesType)
+		}
+	}
+}
+
+func TestGetRulesFromAPI(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "success", "data": {"groups": [{"name": "node", "file": "node.yml", "rules": [
+			{"type": "alerting", "name": "NodeDown", "query": "up == 0", "duration": 300, "health": "ok",
+			 "labels": {"severity": "critical"}, "annotations": {"zabbix_host": "infra"}},
+			{"type": "recording", "name": "node:up:sum", "query": "sum(up)", "health": "ok"},
+			{"type": "alerting", "name": "NodeBroken", "query": "broken(", "health": "err", "lastError": "parse error"}
+		]}]}}`)
+	}))
+	defer server.Close()
+
+	rules, err := GetRulesFromAPI(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("GetRulesFromAPI: %s", err)
+	}
+
+	expected := []PrometheusRule{
+		{
+			Name: "NodeDown", Group: "node", Query: "up == 0", Duration: 5 * time.Minute, Health: "ok",
+			Labels: map[string]string{"severity": "critical"}, Annotations: map[string]string{"zabbix_host": "infra"},
+		},
+		{
+			Name: "NodeBroken", Group: "node", Query: "broken(", Health: "err",
+			Labels: map[string]string{}, Annotations: map[string]string{},
+		},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rules)
+	}
+}
+
+func TestGetRulesFromAPIError(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{name: "error status", status: http.StatusServiceUnavailable, body: `{"status": "error"}`},
+		{name: "error response", status: http.StatusOK, body: `{"status": "error", "errorType": "unavailable", "error": "not ready"}`},
+		{name: "invalid JSON", status: http.StatusOK, body: `<html>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			}))
+			defer server.Close()
+
+			if _, err := GetRulesFromAPI(server.Client(), server.URL); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestGetRulesFromURL(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Rules page of Prometheus 1.x
+		fmt.Fprint(w, `<html><body><h2>Rules</h2><pre>ALERT <a href="/graph?g0.expr=ALERT">NodeDown</a>
+  IF up == 0
+  FOR 5m
+  ANNOTATIONS {summary = &#34;Node down&#34;, zabbix_host = &#34;infra&#34;}<br/>ALERT <a href="/graph?g0.expr=ALERT">DeadMansSwitch</a>
+  IF vector(1)<br/></pre></body></html>`)
+	}))
+	defer server.Close()
+
+	rules, err := GetRulesFromURL(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("GetRulesFromURL: %s", err)
+	}
+
+	expected := []PrometheusRule{
+		{Name: "NodeDown", Labels: map[string]string{}, Annotations: map[string]string{"summary": "Node down", "zabbix_host": "infra"}},
+		{Name: "DeadMansSwitch", Labels: map[string]string{}, Annotations: map[string]string{}},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %+v, got %+v", expected, rules)
+	}
+}
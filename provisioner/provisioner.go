@@ -1,40 +1,95 @@
 package provisioner
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"github.com/gmauleon/zabbix-client"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 )
 
 type Provisioner struct {
-	Api    *zabbix.API
-	Config ProvisionerConfig
+	Api         *zabbix.API
+	Config      ProvisionerConfig
+	RuleSources []RuleSource
+	Changed     chan struct{}
+	HostConfigs []HostConfig
+	LoggedIn    bool
+	// Session or API token sent in an Authorization header instead of the auth field, Zabbix 6.4+
+	BearerToken string
+	// Disable the deletion guard, for intentional bulk removals
+	AllowMassDeletion bool
+	// First time hosts not configured anymore were seen, for the prune grace period
+	OrphanHosts map[string]time.Time
+	// Hosts and host groups to prune in the current cycle, and the number of managed items and triggers of those hosts
+	PruneHosts      zabbix.Hosts
+	PruneHostGroups zabbix.HostGroups
+	PruneObjects    int
+	Status          *Status
+	// Fingerprint of the rules read by the last FillFromPrometheus
+	Fingerprint string
+	// Fingerprint of the last successful cycle that queried Zabbix, and when it ran
+	SyncedFingerprint string
+	LastFullSync      time.Time
+	// Hashes of the desired fields of the managed items and triggers at the last successful cycle, see CustomZabbix.Differences
+	LastDesired    map[string]string
+	LastDriftCheck time.Time
+	// Detected once and again after a failed cycle or a new session, see DetectVersion
+	ZabbixVersion *ZabbixVersion
+	// Last version detected, kept by ForgetVersion to notice upgrades
+	LastZabbixVersion *ZabbixVersion
 	*CustomZabbix
 }
 
 type ProvisionerConfig struct {
-	RulesUrl             string `yaml:"rulesUrl"`
-	RulesPollingInterval int    `yaml:"rulesPollingTime"`
+	RulesUrl             string             `yaml:"rulesUrl"`
+	RulesType            string             `yaml:"rulesType"`
+	RulesPollingInterval int                `yaml:"rulesPollingTime"`
+	RuleSources          []RuleSourceConfig `yaml:"ruleSources"`
+
+	RetryInitialInterval    int `yaml:"retryInitialInterval"`
+	RetryMaxInterval        int `yaml:"retryMaxInterval"`
+	CircuitBreakerThreshold int `yaml:"circuitBreakerThreshold"`
 
 	ZabbixApiUrl      string       `yaml:"zabbixApiUrl"`
 	ZabbixApiCAFile   string       `yaml:"zabbixApiCAFile"`
 	ZabbixApiUser     string       `yaml:"zabbixApiUser"`
 	ZabbixApiPassword string       `yaml:"zabbixApiPassword"`
+	ZabbixApiToken    string       `yaml:"zabbixApiToken"`
+	ZabbixApiVersion  string       `yaml:"zabbixApiVersion"`
 	ZabbixKeyPrefix   string       `yaml:"zabbixKeyPrefix"`
 	ZabbixHosts       []HostConfig `yaml:"zabbixHosts"`
+
+	HostTemplates []HostTemplateConfig `yaml:"hostTemplates"`
+	TemplateGroup string               `yaml:"templateGroup"`
+
+	Prune         PruneConfig         `yaml:"prune"`
+	DeletionGuard DeletionGuardConfig `yaml:"deletionGuard"`
+	Drift         DriftConfig         `yaml:"drift"`
+
+	SeverityMapping SeverityMappingConfig `yaml:"severityMapping"`
+
+	ListenAddress     string `yaml:"listenAddress"`
+	ReconcileDebounce int    `yaml:"reconcileDebounce"`
+
+	FullResyncInterval int `yaml:"fullResyncInterval"`
 }
 
 type HostConfig struct {
 	Name                    string            `yaml:"name"`
 	Selector                map[string]string `yaml:"selector"`
+	LabelSelector           map[string]string `yaml:"labelSelector"`
+	GroupSelector           []string          `yaml:"groupSelector"`
+	SourceSelector          []string          `yaml:"sourceSelector"`
+	AnnotationMatchers      []Matcher         `yaml:"annotationMatchers"`
+	LabelMatchers           []Matcher         `yaml:"labelMatchers"`
+	MatchAll                bool              `yaml:"matchAll"`
+	Tenants                 []string          `yaml:"tenants"`
 	HostGroups              []string          `yaml:"hostGroups"`
 	Tag                     string            `yaml:"tag"`
 	DeploymentStatus        string            `yaml:"deploymentStatus"`
@@ -42,41 +97,47 @@ type HostConfig struct {
 	ItemDefaultHistory      string            `yaml:"itemDefaultHistory"`
 	ItemDefaultTrends       string            `yaml:"itemDefaultTrends"`
 	ItemDefaultTrapperHosts string            `yaml:"itemDefaultTrapperHosts"`
+	DriftPolicy             string            `yaml:"driftPolicy"`
+	ZabbixTemplate          string            `yaml:"zabbixTemplate"`
+	LabelTags               LabelTagsConfig   `yaml:"labelTags"`
+	KeyTemplate             string            `yaml:"keyTemplate"`
+	Discovery               DiscoveryConfig   `yaml:"discovery"`
+	// Replaces the global severityMapping when set
+	SeverityMapping *SeverityMappingConfig `yaml:"severityMapping"`
 }
 
-func New(cfg *ProvisionerConfig) *Provisioner {
+func New(cfg *ProvisionerConfig) (*Provisioner, error) {
 
 	// Use the correct CA bundle if provided
 	transport := http.DefaultTransport
 	if len(cfg.ZabbixApiCAFile) != 0 {
-		// Add custom CA certificate
-		caCert, err := ioutil.ReadFile(cfg.ZabbixApiCAFile)
+		tlsConfig, err := NewTLSConfig(TLSConfig{CAFile: cfg.ZabbixApiCAFile})
 		if err != nil {
-			log.Fatalln("error while reading Zabbix CA: ", err)
+			return nil, fmt.Errorf("error while reading Zabbix CA: %s", err)
 		}
-
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
-		tlsConfig := &tls.Config{}
-		tlsConfig.RootCAs = caCertPool
 		transport = &http.Transport{TLSClientConfig: tlsConfig}
 	}
 
-	api := zabbix.NewAPI(cfg.ZabbixApiUrl)
-	api.SetClient(&http.Client{
-		Transport: transport,
-	})
-
-	_, err := api.Login(cfg.ZabbixApiUser, cfg.ZabbixApiPassword)
-	if err != nil {
-		log.Fatalln("error while login to Zabbix:", err)
+	ruleSources := []RuleSource{}
+	for _, sourceConfig := range cfg.GetRuleSourceConfigs() {
+		source, err := NewRuleSource(sourceConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating rule source: %s", err)
+		}
+		ruleSources = append(ruleSources, source)
 	}
 
-	return &Provisioner{
-		Api:    api,
-		Config: *cfg,
+	p := &Provisioner{
+		Config:      *cfg,
+		RuleSources: ruleSources,
+		Changed:     make(chan struct{}, 1),
+		OrphanHosts: map[string]time.Time{},
+		Status:      &Status{},
+		LastDesired: map[string]string{},
 	}
+	p.Api = p.NewZabbixAPI(cfg.ZabbixApiUrl, transport)
+
+	return p, nil
 
 }
 
@@ -89,13 +150,33 @@ func ConfigFromFile(filename string) (cfg *ProvisionerConfig, err error) {
 
 	// Default values
 	config := ProvisionerConfig{
-		RulesUrl:             "https://127.0.0.1/prometheus/rules",
+		RulesUrl:             "https://127.0.0.1/prometheus/api/v1/rules",
 		RulesPollingInterval: 3600,
 		ZabbixApiUrl:         "https://127.0.0.1/zabbix/api_jsonrpc.php",
 		ZabbixApiUser:        "user",
 		ZabbixApiPassword:    "password",
 		ZabbixKeyPrefix:      "prometheus",
 		ZabbixHosts:          []HostConfig{},
+		TemplateGroup:        "Templates",
+
+		RetryInitialInterval:    10,
+		RetryMaxInterval:        600,
+		CircuitBreakerThreshold: 5,
+		ReconcileDebounce:       5,
+		FullResyncInterval:      21600,
+
+		Prune: PruneConfig{
+			MarkerHostGroup: "alertmanager-zabbix-provisioner",
+			GracePeriod:     3600,
+			MaxDeletions:    5,
+		},
+		DeletionGuard: DeletionGuardConfig{
+			MaxDeletionPercent: 50,
+			MinDeletions:       10,
+		},
+		Drift: DriftConfig{
+			Policy: DriftPolicyRevert,
+		},
 	}
 
 	err = yaml.Unmarshal(configFile, &config)
@@ -103,9 +184,48 @@ func ConfigFromFile(filename string) (cfg *ProvisionerConfig, err error) {
 		return nil, fmt.Errorf("can't read the config file: %s", err)
 	}
 
+	sourceNames := map[string]struct{}{}
+	for _, source := range config.RuleSources {
+		if len(source.Name) == 0 {
+			return nil, fmt.Errorf("a rule source must have a name")
+		}
+		if _, ok := sourceNames[source.Name]; ok {
+			return nil, fmt.Errorf("rule source %s is declared more than once", source.Name)
+		}
+		sourceNames[source.Name] = struct{}{}
+	}
+
+	if err := ValidateKeyPrefix(config.ZabbixKeyPrefix); err != nil {
+		return nil, err
+	}
+
+	if !IsValidDriftPolicy(config.Drift.Policy) {
+		return nil, fmt.Errorf("unknown drift policy %q", config.Drift.Policy)
+	}
+
+	if err := config.SeverityMapping.Validate(); err != nil {
+		return nil, err
+	}
+
+	for i := range config.ZabbixHosts {
+		if err := config.ZabbixHosts[i].Validate(); err != nil {
+			return nil, fmt.Errorf("host %s: %s", config.ZabbixHosts[i].Name, err)
+		}
+	}
+
+	for i := range config.HostTemplates {
+		if err := config.HostTemplates[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Prune.Enabled && len(config.Prune.MarkerHostGroup) == 0 {
+		return nil, fmt.Errorf("prune needs a markerHostGroup")
+	}
+
 	log.Info("configuration loaded")
 
-	// If Environment variables are set for zabbix user and password, use those instead
+	// If Environment variables are set for zabbix user, password and token, use those instead
 	zabbixApiUser, ok := os.LookupEnv("ZABBIX_API_USER")
 	if ok {
 		config.ZabbixApiUser = zabbixApiUser
@@ -116,37 +236,406 @@ func ConfigFromFile(filename string) (cfg *ProvisionerConfig, err error) {
 		config.ZabbixApiPassword = zabbixApiPassword
 	}
 
+	zabbixApiToken, ok := os.LookupEnv("ZABBIX_API_TOKEN")
+	if ok {
+		config.ZabbixApiToken = zabbixApiToken
+	}
+
 	return &config, nil
 }
 
+// Copy of the configuration with the passwords and tokens hidden, to be logged
+func (cfg ProvisionerConfig) Redacted() ProvisionerConfig {
+
+	cfg.RulesUrl = redactUrl(cfg.RulesUrl)
+	cfg.ZabbixApiUrl = redactUrl(cfg.ZabbixApiUrl)
+	cfg.ZabbixApiPassword = redact(cfg.ZabbixApiPassword)
+	cfg.ZabbixApiToken = redact(cfg.ZabbixApiToken)
+
+	sources := make([]RuleSourceConfig, len(cfg.RuleSources))
+	for i, source := range cfg.RuleSources {
+		source.Url = redactUrl(source.Url)
+		source.BearerToken = redact(source.BearerToken)
+		if source.BasicAuth != nil {
+			source.BasicAuth = &BasicAuthConfig{Username: source.BasicAuth.Username, Password: redact(source.BasicAuth.Password)}
+		}
+		sources[i] = source
+	}
+	cfg.RuleSources = sources
+
+	return cfg
+}
+
+// Hide a secret, an empty one stays empty to tell it isn't set
+func redact(secret string) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return "<redacted>"
+}
+
+// Hide the password of a URL, if any
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+	return u.Redacted()
+}
+
+// Check the settings of a host and compile its matchers, hosts and host templates share them
+func (h *HostConfig) Validate() error {
+
+	if h.SeverityMapping != nil {
+		if err := h.SeverityMapping.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(h.DriftPolicy) != 0 && !IsValidDriftPolicy(h.DriftPolicy) {
+		return fmt.Errorf("unknown drift policy %q", h.DriftPolicy)
+	}
+
+	if err := h.Discovery.Validate(); err != nil {
+		return err
+	}
+
+	if err := ValidateKeyTemplate(h.KeyTemplate); err != nil {
+		return err
+	}
+
+	for i := range h.AnnotationMatchers {
+		if err := h.AnnotationMatchers[i].Compile(); err != nil {
+			return err
+		}
+	}
+
+	for i := range h.LabelMatchers {
+		if err := h.LabelMatchers[i].Compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (p *Provisioner) Start() {
 
+	// Sources able to watch their rules will wake us up before the end of the polling interval
+	stop := make(chan struct{})
+	defer close(stop)
+	for _, source := range p.RuleSources {
+		if watchable, ok := source.(WatchableRuleSource); ok {
+			go watchable.Watch(p.Changed, stop)
+		}
+	}
+
+	pollingInterval := time.Duration(p.Config.RulesPollingInterval) * time.Second
+	backoff := NewBackoff(
+		time.Duration(p.Config.RetryInitialInterval)*time.Second,
+		time.Duration(p.Config.RetryMaxInterval)*time.Second,
+	)
+	breaker := NewCircuitBreaker(p.Config.CircuitBreakerThreshold)
+
 	for {
 
-		// TODO
-		// TODO: Compare rules and do something only if there is some changes
-		// TODO
+		wait := pollingInterval
 
-		p.CustomZabbix = NewCustomZabbix()
+		triggeredAt, triggered := p.Status.TakeTrigger()
+		startedAt := time.Now()
+		err := p.Reconcile()
+		if triggered {
+			p.Status.SetTriggeredRun(NewRunResult(triggeredAt, startedAt, err))
+		}
+
+		if err == nil {
+			log.Info("Provisioning cycle succeeded")
+			backoff.Reset()
+			breaker.Success()
+		} else {
+			breaker.Failure()
+
+			// Retry quickly on transient errors, but stop hammering Zabbix or Prometheus when it keeps failing
+			if breaker.Open() {
+				log.Errorf("Provisioning cycle failed %d times in a row, waiting for the next polling interval: %s", breaker.Failures(), err)
+			} else {
+				wait = backoff.Next()
+				log.Errorf("Provisioning cycle failed, retrying in %s: %s", wait, err)
+			}
+		}
 
-		p.FillFromPrometheus()
-		p.FillFromZabbix()
-		p.ApplyChanges()
+		select {
+		case <-time.After(wait):
+		case <-p.Changed:
+			log.Info("Rules changed or reconcile requested, starting a new cycle")
+			p.Debounce()
+		}
+	}
+}
 
-		time.Sleep(time.Duration(p.Config.RulesPollingInterval) * time.Second)
+// Wait a bit so that bursts of changes and reconcile requests end up in a single cycle
+func (p *Provisioner) Debounce() {
+	time.Sleep(time.Duration(p.Config.ReconcileDebounce) * time.Second)
+	select {
+	case <-p.Changed:
+	default:
 	}
 }
 
-// Check if a prometheus rule has all the key/value pair declared in the selector configuration for a host
+// Login to Zabbix if not already done
+func (p *Provisioner) Login() error {
+
+	if p.LoggedIn {
+		return nil
+	}
+
+	err := p.DetectVersion()
+	if err != nil {
+		return err
+	}
+
+	// user.login must be called without authentication, an expired session is dropped
+	p.Api.Auth = ""
+	p.BearerToken = ""
+
+	// Zabbix 5.4+ API tokens are used as is, in place of a session
+	auth := p.Config.ZabbixApiToken
+	if len(auth) == 0 {
+		auth, err = p.UserLogin()
+		if err != nil {
+			return fmt.Errorf("error while login to Zabbix: %s", err)
+		}
+	}
+
+	if p.UseBearerAuth() {
+		p.BearerToken = auth
+	} else {
+		p.Api.Auth = auth
+	}
+
+	p.LoggedIn = true
+	return nil
+}
+
+// Run a single provisioning cycle
+func (p *Provisioner) Reconcile() error {
+
+	start := time.Now()
+	err := p.reconcile()
+	ReconcileDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		ReconcileErrors.Inc()
+		// API errors may come from an upgrade, like application calls on Zabbix 5.4+
+		p.ForgetVersion()
+		return err
+	}
+
+	LastSuccess.SetToCurrentTime()
+	p.Status.Succeeded()
+	return nil
+}
+
+func (p *Provisioner) reconcile() error {
+
+	// Rules are read differently depending on the version
+	err := p.DetectVersion()
+	if err != nil {
+		return err
+	}
+
+	p.CustomZabbix = NewCustomZabbix()
+
+	err = p.FillFromPrometheus()
+	if err != nil {
+		return fmt.Errorf("reading rules: %s", err)
+	}
+
+	if p.IsSynced() {
+		log.Info("Rules and configuration unchanged since the last cycle, skipping Zabbix")
+		ReconcileSkipped.Inc()
+		return nil
+	}
+
+	err = p.Login()
+	if err != nil {
+		return err
+	}
+
+	err = p.FillFromZabbix()
+	if err != nil {
+		return fmt.Errorf("reading Zabbix: %s", err)
+	}
+
+	p.CheckDrift()
+
+	err = p.FillPruneCandidates()
+	if err != nil {
+		return fmt.Errorf("pruning Zabbix: %s", err)
+	}
+
+	err = p.CheckDeletions()
+	if err != nil {
+		return fmt.Errorf("deletion guard: %s", err)
+	}
+
+	err = p.CheckPrune()
+	if err != nil {
+		return fmt.Errorf("prune guard: %s", err)
+	}
+
+	err = p.ApplyChanges()
+	if err != nil {
+		return fmt.Errorf("applying changes to Zabbix: %s", err)
+	}
+
+	if p.Config.Prune.Enabled {
+		err = p.Prune()
+		if err != nil {
+			return fmt.Errorf("pruning Zabbix: %s", err)
+		}
+	}
+
+	p.SyncedFingerprint = p.Fingerprint
+	p.LastFullSync = time.Now()
+	p.LastDesired = p.DesiredState()
+	return nil
+}
+
+// Check if Zabbix is already in sync with the rules and configuration, a full resync still happens every FullResyncInterval
+func (p *Provisioner) IsSynced() bool {
+
+	if p.Config.FullResyncInterval == 0 || p.Fingerprint != p.SyncedFingerprint {
+		return false
+	}
+
+	// Hosts waiting for their prune grace period need cycles to be deleted
+	if len(p.OrphanHosts) != 0 {
+		return false
+	}
+
+	if p.IsDriftCheckDue() {
+		return false
+	}
+
+	return time.Since(p.LastFullSync) < time.Duration(p.Config.FullResyncInterval)*time.Second
+}
+
+// Compute what a provisioning cycle would change, without touching Zabbix
+func (p *Provisioner) Plan() (*Plan, error) {
+
+	err := p.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	p.CustomZabbix = NewCustomZabbix()
+
+	err = p.FillFromPrometheus()
+	if err != nil {
+		return nil, fmt.Errorf("reading rules: %s", err)
+	}
+
+	err = p.FillFromZabbix()
+	if err != nil {
+		return nil, fmt.Errorf("reading Zabbix: %s", err)
+	}
+
+	// Drifted objects that would be kept don't appear in the plan
+	p.CustomZabbix.KeepDrift(p.CustomZabbix.Drift(p.LastDesired))
+
+	plan := p.CustomZabbix.Plan()
+
+	err = p.FillPruneCandidates()
+	if err != nil {
+		return nil, fmt.Errorf("pruning Zabbix: %s", err)
+	}
+	if p.Config.Prune.Enabled {
+		for _, host := range p.PruneHosts {
+			plan.Add(Change{Action: ActionDelete, Type: "host", Name: host.Name})
+		}
+		for _, hostGroup := range p.PruneHostGroups {
+			plan.Add(Change{Action: ActionDelete, Type: "hostgroup", Name: hostGroup.Name})
+		}
+		plan.Sort()
+	}
+
+	// A real cycle would stop there
+	if _, err := p.GuardDeletions(); err != nil {
+		plan.DeletionGuard = err.Error()
+	} else if err := p.GuardPrune(); err != nil {
+		plan.DeletionGuard = err.Error()
+	}
+
+	return plan, nil
+}
+
+// Check if a prometheus rule matches all the selectors declared in the configuration for a host
+// A host without any selector never matches, unless matchAll is set
 func (p *Provisioner) IsMatching(config HostConfig, rule PrometheusRule) bool {
 
-	if len(config.Selector) == 0 {
+	if !config.MatchAll && !config.HasSelectors() {
+		return false
+	}
+
+	// When tenants are declared for a host, only rules of those tenants are selected
+	if !IsMatchingList(config.Tenants, rule.Tenant) {
+		return false
+	}
+
+	if !IsMatchingList(config.GroupSelector, rule.Group) {
+		return false
+	}
+
+	if !IsMatchingList(config.SourceSelector, rule.Source) {
+		return false
+	}
+
+	if !IsMatchingMap(config.Selector, rule.Annotations) {
+		return false
+	}
+
+	if !IsMatchingMap(config.LabelSelector, rule.Labels) {
+		return false
+	}
+
+	if !IsMatchingMatchers(config.AnnotationMatchers, rule.Annotations) {
 		return false
 	}
 
-	for hostKey, hostValue := range config.Selector {
-		if ruleValue, ok := rule.Annotations[hostKey]; ok {
-			if hostValue != ruleValue {
+	if !IsMatchingMatchers(config.LabelMatchers, rule.Labels) {
+		return false
+	}
+
+	return true
+}
+
+// Check if two hosts select the same rules
+func (p *Provisioner) SelectSameRules(i HostConfig, j HostConfig, rules []PrometheusRule) bool {
+	for _, rule := range rules {
+		if p.IsMatching(i, rule) != p.IsMatching(j, rule) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if at least one selector is declared for a host
+func (config *HostConfig) HasSelectors() bool {
+	return len(config.Selector) != 0 ||
+		len(config.LabelSelector) != 0 ||
+		len(config.GroupSelector) != 0 ||
+		len(config.SourceSelector) != 0 ||
+		len(config.AnnotationMatchers) != 0 ||
+		len(config.LabelMatchers) != 0
+}
+
+// Check if all the key/value pairs of a selector are present in a map, an empty selector always matches
+func IsMatchingMap(selector map[string]string, values map[string]string) bool {
+
+	for selectorKey, selectorValue := range selector {
+		if value, ok := values[selectorKey]; ok {
+			if selectorValue != value {
 				return false
 			}
 		} else {
@@ -156,12 +645,52 @@ func (p *Provisioner) IsMatching(config HostConfig, rule PrometheusRule) bool {
 	return true
 }
 
+// Check if a value is part of a list, an empty list always matches
+func IsMatchingList(list []string, value string) bool {
+
+	if len(list) == 0 {
+		return true
+	}
+
+	for _, listValue := range list {
+		if listValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the rules from all the configured sources, merged in a single list
+func (p *Provisioner) GetRules() ([]PrometheusRule, error) {
+	return GetRulesFromSources(p.RuleSources)
+}
+
 // Create hosts structures and populate them from Prometheus rules
-func (p *Provisioner) FillFromPrometheus() {
+func (p *Provisioner) FillFromPrometheus() error {
+
+	rules, err := p.GetRules()
+	if err != nil {
+		return err
+	}
+
+	p.Fingerprint, err = Fingerprint(rules, p.Config)
+	if err != nil {
+		return err
+	}
 
-	rules := GetRulesFromURL(p.Config.RulesUrl)
+	hostConfigs, err := p.GetHostConfigs(rules)
+	if err != nil {
+		return err
+	}
+	p.HostConfigs = hostConfigs
+
+	// Index of the rule giving each key, by host or template
+	keyRules := map[string]map[string]int{}
+	collisions := 0
+	// First host of each template, the one giving its rules
+	templateHosts := map[string]HostConfig{}
 
-	for _, hostConfig := range p.Config.ZabbixHosts {
+	for _, hostConfig := range p.HostConfigs {
 
 		// Create an internal host object
 		newHost := &CustomHost{
@@ -187,14 +716,16 @@ func (p *Provisioner) FillFromPrometheus() {
 					},
 				},
 			},
-			HostGroups:   make(map[string]struct{}, len(hostConfig.HostGroups)),
-			Items:        map[string]*CustomItem{},
-			Applications: map[string]*CustomApplication{},
-			Triggers:     map[string]*CustomTrigger{},
+			HostGroups:      make(map[string]struct{}, len(hostConfig.HostGroups)),
+			Items:           map[string]*CustomItem{},
+			Applications:    map[string]*CustomApplication{},
+			Triggers:        map[string]*CustomTrigger{},
+			Templates:       map[string]struct{}{},
+			LinkedTemplates: map[string]string{},
 		}
 
 		// Create host groups from the configuration file and link them to this host
-		for _, hostGroupName := range hostConfig.HostGroups {
+		for _, hostGroupName := range p.GetHostGroupNames(hostConfig) {
 			p.AddHostGroup(&CustomHostGroup{
 				State: StateNew,
 				HostGroup: zabbix.HostGroup{
@@ -205,14 +736,55 @@ func (p *Provisioner) FillFromPrometheus() {
 			newHost.HostGroups[hostGroupName] = struct{}{}
 		}
 
+		// Items, triggers and applications go to the managed template of the host if it has one
+		target := newHost
+		if len(hostConfig.ZabbixTemplate) != 0 {
+			target = p.GetTemplate(hostConfig.ZabbixTemplate)
+			newHost.Templates[target.Name] = struct{}{}
+
+			// Hosts sharing a template only link it, the rules are the ones of the first host
+			if firstHost, ok := templateHosts[target.Name]; ok {
+				if !p.SelectSameRules(firstHost, hostConfig, rules) {
+					log.Warnf("Host %s selects other rules than %s, it only gets the rules of %s through template %s",
+						hostConfig.Name, firstHost.Name, firstHost.Name, target.Name)
+				}
+				log.Debugf("Host from Prometheus: %+v", newHost)
+				p.AddHost(newHost)
+				continue
+			}
+			templateHosts[target.Name] = hostConfig
+		}
+
+		if _, ok := keyRules[target.Name]; !ok {
+			keyRules[target.Name] = map[string]int{}
+		}
+
 		// Parse Prometheus rules and create corresponding items/triggers and applications for this host
-		for _, rule := range rules {
+		for i, rule := range rules {
 
 			if !p.IsMatching(hostConfig, rule) {
 				continue
 			}
 
-			key := fmt.Sprintf("prometheus.%s", strings.ToLower(rule.Name))
+			key, err := p.GetItemKey(hostConfig, rule)
+			if err != nil {
+				return err
+			}
+
+			// Another rule giving the same key is skipped, the first one wins
+			if owner, ok := keyRules[target.Name][key]; ok {
+				log.Errorf("Rule %s gives the key %s on %s, already used by rule %s, it is skipped",
+					DescribeRule(rule), key, target.Name, DescribeRule(rules[owner]))
+				collisions++
+				continue
+			}
+			keyRules[target.Name][key] = i
+
+			driftPolicy := p.GetDriftPolicy(hostConfig, rule)
+
+			// Discovered items get no applications, the item only carries the fields of the prototypes
+			discoveryLabels := p.GetDiscoveryLabels(hostConfig, rule)
+			useApplications := !p.UseTags() && len(discoveryLabels) == 0
 
 			newItem := &CustomItem{
 				State: StateNew,
@@ -227,16 +799,22 @@ func (p *Provisioner) FillFromPrometheus() {
 					TrapperHosts: hostConfig.ItemDefaultTrapperHosts,
 				},
 				Applications: map[string]struct{}{},
+				DriftPolicy:  driftPolicy,
 			}
 
 			newTrigger := &CustomTrigger{
 				State: StateNew,
 				Trigger: zabbix.Trigger{
 					Description: rule.Name,
-					Expression:  fmt.Sprintf("{%s:%s.last()}<>0", newHost.Name, key),
+					Expression:  p.TriggerFunction(target.Name, key, "last", "") + "<>0",
 				},
+				DriftPolicy: driftPolicy,
 			}
 
+			newTrigger.Priority = p.GetTriggerPriority(hostConfig, rule)
+
+			tags := []Tag{}
+
 			for k, v := range rule.Annotations {
 				switch k {
 				case "zabbix_applications":
@@ -251,7 +829,9 @@ func (p *Provisioner) FillFromPrometheus() {
 							},
 						}
 
-						newHost.AddApplication(newApplication)
+						if useApplications {
+							target.AddApplication(newApplication)
+						}
 
 						if _, ok := newItem.Applications[applicationName]; !ok {
 							newItem.Applications[applicationName] = struct{}{}
@@ -285,8 +865,9 @@ func (p *Provisioner) FillFromPrometheus() {
 					newTrigger.Description = v
 				case "zabbix_trigger_description":
 					newTrigger.Comments = v
-				case "zabbix_trigger_severity":
-					newTrigger.Priority = GetZabbixPriority(v)
+				case "zabbix_tags":
+					// List of name:value tags separated by comma, Zabbix 3.2+ for triggers and 5.4+ for items
+					tags = append(tags, ParseTags(v)...)
 				default:
 					continue
 				}
@@ -294,51 +875,89 @@ func (p *Provisioner) FillFromPrometheus() {
 
 			// If no applications are found in the rule, add the default application declared in the configuration
 			if len(newItem.Applications) == 0 {
-				newHost.AddApplication(&CustomApplication{
-					State: StateNew,
-					Application: zabbix.Application{
-						Name: hostConfig.ItemDefaultApplication,
-					},
-				})
+				if useApplications {
+					target.AddApplication(&CustomApplication{
+						State: StateNew,
+						Application: zabbix.Application{
+							Name: hostConfig.ItemDefaultApplication,
+						},
+					})
+				}
 				newItem.Applications[hostConfig.ItemDefaultApplication] = struct{}{}
 			}
 
+			tags = append(tags, hostConfig.LabelTags.Tags(rule.Labels)...)
+
+			// Zabbix 5.4+ has no applications, they become Application tags as they do when upgrading Zabbix
+			if p.UseTags() {
+				for applicationName := range newItem.Applications {
+					if len(applicationName) != 0 {
+						tags = append(tags, Tag{Tag: ApplicationTag, Value: applicationName})
+					}
+				}
+				newItem.Applications = map[string]struct{}{}
+				newItem.Tags = NewTagSet(tags)
+			}
+
+			// Triggers have tags since Zabbix 3.2, that's what actions route on
+			if p.UseTriggerTags() {
+				newTrigger.Tags = NewTagSet(tags)
+			}
+
+			// One item and trigger per alert instance, discovered from the labels sent by the webhook
+			if len(discoveryLabels) != 0 {
+				discoveryRule := target.AddDiscoveryRule(p.NewDiscoveryRule(target, hostConfig, rule, newItem, newTrigger, discoveryLabels))
+				log.Debugf("Discovery rule from Prometheus: %+v", discoveryRule)
+				continue
+			}
+
+			RecordDesiredItem(newItem)
 			log.Debugf("Item from Prometheus: %+v", newItem)
-			newHost.AddItem(newItem)
+			target.AddItem(newItem)
 
+			RecordDesiredTrigger(newTrigger)
 			log.Debugf("Trigger from Prometheus: %+v", newTrigger)
-			newHost.AddTrigger(newTrigger)
+			target.AddTrigger(newTrigger)
 
 			// Add the special "No Data" trigger if requested
 			if delay, ok := rule.Annotations["zabbix_trigger_nodata"]; ok {
 				noDataTrigger := &CustomTrigger{
-					State:   StateNew,
-					Trigger: newTrigger.Trigger,
+					State:       StateNew,
+					Trigger:     newTrigger.Trigger,
+					DriftPolicy: driftPolicy,
+					Tags:        newTrigger.Tags,
 				}
 
 				noDataTrigger.Trigger.Description = fmt.Sprintf("%s - no data for the last %s seconds", newTrigger.Trigger.Description, delay)
-				noDataTrigger.Trigger.Expression = fmt.Sprintf("{%s:%s.nodata(%s)}", newHost.Name, key, delay)
+				noDataTrigger.Trigger.Expression = p.TriggerFunction(target.Name, key, "nodata", delay)
+				RecordDesiredTrigger(noDataTrigger)
 				log.Debugf("Trigger from Prometheus: %+v", noDataTrigger)
-				newHost.AddTrigger(noDataTrigger)
+				target.AddTrigger(noDataTrigger)
 			}
 		}
 		log.Debugf("Host from Prometheus: %+v", newHost)
 		p.AddHost(newHost)
 	}
+
+	KeyCollisions.Set(float64(collisions))
+	return nil
 }
 
 // Update created hosts with the current state in Zabbix
-func (p *Provisioner) FillFromZabbix() {
+func (p *Provisioner) FillFromZabbix() error {
 
-	hostNames := make([]string, len(p.Config.ZabbixHosts))
+	hostNames := make([]string, len(p.HostConfigs))
 	hostGroupNames := []string{}
-	for i, _ := range p.Config.ZabbixHosts {
-		hostNames[i] = p.Config.ZabbixHosts[i].Name
-		hostGroupNames = append(hostGroupNames, p.Config.ZabbixHosts[i].HostGroups...)
+	for i, _ := range p.HostConfigs {
+		hostNames[i] = p.HostConfigs[i].Name
+		hostGroupNames = append(hostGroupNames, p.GetHostGroupNames(p.HostConfigs[i])...)
+	}
+	if len(p.GetTemplateNames()) != 0 && !p.UseTemplateGroups() {
+		hostGroupNames = append(hostGroupNames, p.Config.TemplateGroup)
 	}
 
 	// Getting Zabbix HostGroups
-	zabbixHostGroups, err := p.Api.HostGroupsGet(zabbix.Params{
+	zabbixHostGroups, err := p.HostGroupsGet(zabbix.Params{
 		"output": "extend",
 		"filter": map[string][]string{
 			"name": hostGroupNames,
@@ -346,7 +965,7 @@ func (p *Provisioner) FillFromZabbix() {
 	})
 
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("getting host groups: %s", err)
 	}
 
 	for _, zabbixHostGroup := range zabbixHostGroups {
@@ -357,7 +976,7 @@ func (p *Provisioner) FillFromZabbix() {
 	}
 
 	// Getting Zabbix Hosts
-	zabbixHosts, err := p.Api.HostsGet(zabbix.Params{
+	zabbixHosts, err := p.HostsGet(zabbix.Params{
 		"output": "extend",
 		"selectInventory": []string{
 			"tag",
@@ -369,19 +988,19 @@ func (p *Provisioner) FillFromZabbix() {
 	})
 
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("getting hosts: %s", err)
 	}
 
 	for _, zabbixHost := range zabbixHosts {
 
 		// Getting Zabbix HostGroups
-		zabbixHostGroups, err := p.Api.HostGroupsGet(zabbix.Params{
+		zabbixHostGroups, err := p.HostGroupsGet(zabbix.Params{
 			"output":  "extend",
 			"hostids": zabbixHost.HostId,
 		})
 
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("getting host groups of host %s: %s", zabbixHost.Host, err)
 		}
 
 		hostGroups := make(map[string]struct{}, len(zabbixHostGroups))
@@ -394,112 +1013,205 @@ func (p *Provisioner) FillFromZabbix() {
 		delete(zabbixHost.Inventory, "hostid")
 
 		oldHost := p.AddHost(&CustomHost{
-			State:        StateOld,
-			Host:         zabbixHost,
-			HostGroups:   hostGroups,
-			Items:        map[string]*CustomItem{},
-			Applications: map[string]*CustomApplication{},
-			Triggers:     map[string]*CustomTrigger{},
+			State:           StateOld,
+			Host:            zabbixHost,
+			HostGroups:      hostGroups,
+			Items:           map[string]*CustomItem{},
+			Applications:    map[string]*CustomApplication{},
+			Triggers:        map[string]*CustomTrigger{},
+			LinkedTemplates: map[string]string{},
 		})
 		log.Debugf("Host from Zabbix: %+v", oldHost)
 
-		// Getting host applications
-		zabbixApplications, err := p.Api.ApplicationsGet(zabbix.Params{
-			"output":  "extend",
-			"hostids": oldHost.HostId,
-		})
-
+		err = p.FillObjectsFromZabbix(oldHost)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+	}
 
-		for _, zabbixApplication := range zabbixApplications {
-			oldHost.AddApplication(&CustomApplication{
-				State:       StateOld,
-				Application: zabbixApplication,
-			})
-		}
+	return p.FillTemplatesFromZabbix()
+}
 
-		// Getting Zabbix Items
-		zabbixItems, err := p.Api.ItemsGet(zabbix.Params{
-			"output":  "extend",
-			"hostids": oldHost.Host.HostId,
-		})
+// Load the items, applications and triggers of a host or a template from Zabbix, ignoring inherited ones
+func (p *Provisioner) FillObjectsFromZabbix(oldHost *CustomHost) error {
+
+	// Getting Zabbix Items, discovered ones belong to their discovery rule
+	zabbixItems, err := p.ItemsGet(zabbix.Params{
+		"output":    "extend",
+		"hostids":   oldHost.Host.HostId,
+		"inherited": false,
+		"filter":    map[string]string{"flags": "0"},
+	})
+
+	if err != nil {
+		return fmt.Errorf("getting items of host %s: %s", oldHost.Name, err)
+	}
 
+	// Zabbix 5.4+ has tags instead of applications
+	var itemTags map[string][]Tag
+	if p.UseTags() {
+		itemTags, err = p.ItemsTagsGet(oldHost.HostId)
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("getting item tags of host %s: %s", oldHost.Name, err)
 		}
+	}
 
-		for _, zabbixItem := range zabbixItems {
+	// Applications used by items we don't own must not be deleted, nor the ones none of our items use
+	foreignApplications := map[string]struct{}{}
+	ownedApplications := map[string]struct{}{}
 
-			newItem := &CustomItem{
-				State: StateOld,
-				Item:  zabbixItem,
-			}
+	// Items left by a previous key prefix are never owned again, only a warning tells about them
+	foreignPrefixKeys := []string{}
+
+	for _, zabbixItem := range zabbixItems {
 
+		zabbixApplications := zabbix.Applications{}
+		if !p.UseTags() {
 			// Getting applications linkd to that item
-			zabbixApplications, err := p.Api.ApplicationsGet(zabbix.Params{
+			zabbixApplications, err = p.ApplicationsGet(zabbix.Params{
 				"output":  "extend",
 				"itemids": zabbixItem.ItemId,
 			})
 
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("getting applications of item %s: %s", zabbixItem.Key, err)
 			}
+		}
 
-			newItem.Applications = make(map[string]struct{}, len(zabbixApplications))
+		if !p.IsOwnedItem(zabbixItem) {
+			log.Debugf("Ignoring item not managed by the provisioner: %s", zabbixItem.Key)
+			if p.IsForeignPrefixItem(zabbixItem) {
+				foreignPrefixKeys = append(foreignPrefixKeys, zabbixItem.Key)
+			}
 			for _, zabbixApplication := range zabbixApplications {
-				newItem.Applications[zabbixApplication.Name] = struct{}{}
+				foreignApplications[zabbixApplication.Name] = struct{}{}
 			}
+			continue
+		}
+
+		newItem := &CustomItem{
+			State: StateOld,
+			Item:  zabbixItem,
+			Tags:  NewTagSet(itemTags[zabbixItem.ItemId]),
+		}
 
-			log.Debugf("Item from Zabbix: %+v", newItem)
-			oldHost.AddItem(newItem)
+		newItem.Applications = make(map[string]struct{}, len(zabbixApplications))
+		for _, zabbixApplication := range zabbixApplications {
+			newItem.Applications[zabbixApplication.Name] = struct{}{}
+			ownedApplications[zabbixApplication.Name] = struct{}{}
 		}
 
-		// Get all the triggers for that host
-		zabbixTriggers, err := p.Api.TriggersGet(zabbix.Params{
-			"output":           "extend",
-			"hostids":          oldHost.Host.HostId,
-			"expandExpression": true,
+		log.Debugf("Item from Zabbix: %+v", newItem)
+		oldHost.AddItem(newItem)
+	}
+
+	if len(foreignPrefixKeys) != 0 {
+		log.Warnf("Host %s has %d trapper items not starting with %q that look provisioned, like %s: they are left alone, "+
+			"if zabbixKeyPrefix was changed they must be deleted by hand", oldHost.Name, len(foreignPrefixKeys), p.KeyPrefix(), foreignPrefixKeys[0])
+	}
+
+	// Getting host applications, Zabbix 5.4+ has none
+	if !p.UseTags() {
+		zabbixApplications, err := p.ApplicationsGet(zabbix.Params{
+			"output":    "extend",
+			"hostids":   oldHost.HostId,
+			"inherited": false,
 		})
 
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("getting applications of host %s: %s", oldHost.Name, err)
 		}
 
-		for _, zabbixTrigger := range zabbixTriggers {
-			newTrigger := &CustomTrigger{
-				State:   StateOld,
-				Trigger: zabbixTrigger,
+		for _, zabbixApplication := range zabbixApplications {
+
+			_, desired := oldHost.Applications[zabbixApplication.Name]
+			_, foreign := foreignApplications[zabbixApplication.Name]
+			_, owned := ownedApplications[zabbixApplication.Name]
+
+			// An application we don't need anymore is only deleted if our items are the only ones using it,
+			// shared and hand-made applications are kept as they are
+			state := StateOld
+			if !desired && (foreign || !owned) {
+				log.Debugf("Keeping application not managed by the provisioner: %s", zabbixApplication.Name)
+				state = StateEqual
 			}
 
-			log.Debugf("Triggers from Zabbix: %+v", newTrigger)
-			oldHost.AddTrigger(newTrigger)
+			oldHost.AddApplication(&CustomApplication{
+				State:       state,
+				Application: zabbixApplication,
+			})
+		}
+	}
+
+	// Get all the triggers for that host
+	zabbixTriggers, err := p.TriggersGet(zabbix.Params{
+		"output":           "extend",
+		"hostids":          oldHost.Host.HostId,
+		"expandExpression": true,
+		"inherited":        false,
+		"filter":           map[string]string{"flags": "0"},
+	})
+
+	if err != nil {
+		return fmt.Errorf("getting triggers of host %s: %s", oldHost.Name, err)
+	}
+
+	var triggerTags map[string][]Tag
+	if p.UseTriggerTags() {
+		triggerTags, err = p.TriggersTagsGet(oldHost.HostId)
+		if err != nil {
+			return fmt.Errorf("getting trigger tags of host %s: %s", oldHost.Name, err)
+		}
+	}
+
+	for _, zabbixTrigger := range zabbixTriggers {
+
+		if !p.IsOwnedTrigger(zabbixTrigger) {
+			log.Debugf("Ignoring trigger not managed by the provisioner: %s", zabbixTrigger.Expression)
+			continue
 		}
+
+		newTrigger := &CustomTrigger{
+			State:   StateOld,
+			Trigger: zabbixTrigger,
+			Tags:    NewTagSet(triggerTags[zabbixTrigger.TriggerId]),
+		}
+
+		log.Debugf("Triggers from Zabbix: %+v", newTrigger)
+		oldHost.AddTrigger(newTrigger)
 	}
+
+	return p.FillDiscoveryRulesFromZabbix(oldHost)
 }
 
-func (p *Provisioner) ApplyChanges() {
+func (p *Provisioner) ApplyChanges() error {
 
 	hostGroupsByState := p.GetHostGroupsByState()
 	if len(hostGroupsByState[StateNew]) != 0 {
 		log.Debugf("Creating HostGroups: %+v\n", hostGroupsByState[StateNew])
-		err := p.Api.HostGroupsCreate(hostGroupsByState[StateNew])
+		err := p.HostGroupsCreate(hostGroupsByState[StateNew])
 		if err != nil {
-			log.Fatalln("Creating hostgroups:", err)
+			return fmt.Errorf("creating hostgroups: %s", err)
 		}
+		CountChanges("hostgroup", ActionCreate, len(hostGroupsByState[StateNew]))
 	}
 
 	// Make sure we update ids for the newly created host groups
 	p.PropagateCreatedHostGroups(hostGroupsByState[StateNew])
 
+	err := p.ApplyTemplates()
+	if err != nil {
+		return err
+	}
+
 	hostsByState := p.GetHostsByState()
 	if len(hostsByState[StateNew]) != 0 {
 		log.Debugf("Creating Hosts: %+v\n", hostsByState[StateNew])
-		err := p.Api.HostsCreate(hostsByState[StateNew])
+		err := p.HostsCreate(hostsByState[StateNew])
 		if err != nil {
-			log.Fatalln("Creating host:", err)
+			return fmt.Errorf("creating hosts: %s", err)
 		}
+		CountChanges("host", ActionCreate, len(hostsByState[StateNew]))
 	}
 
 	// Make sure we update ids for the newly created hosts
@@ -507,47 +1219,42 @@ func (p *Provisioner) ApplyChanges() {
 
 	if len(hostsByState[StateUpdated]) != 0 {
 		log.Debugf("Updating Hosts: %+v\n", hostsByState[StateUpdated])
-		err := p.Api.HostsUpdate(hostsByState[StateUpdated])
+		err := p.HostsUpdate(hostsByState[StateUpdated])
 		if err != nil {
-			log.Fatalln("Updating host:", err)
+			return fmt.Errorf("updating hosts: %s", err)
 		}
+		CountChanges("host", ActionUpdate, len(hostsByState[StateUpdated]))
 	}
 
-	//if len(hostsByState[StateOld]) != 0 {
-	//	log.Debugf("Deleting Hosts: %+v\n", hostsByState[StateOld])
-	//	err := p.Api.HostsDelete(hostsByState[StateOld])
-	//	if err != nil {
-	//		log.Fatalln("Deleting host:", err)
-	//	}
-	//}
+	// Hosts and host groups removed from the configuration are only deleted by Prune
 
-	//if len(hostGroupsByState[StateOld]) != 0 {
-	//	log.Debugf("Deleting HostGroups: %+v\n", hostGroupsByState[StateOld])
-	//	err := p.Api.HostGroupsDelete(hostGroupsByState[StateOld])
-	//	if err != nil {
-	//		log.Fatalln("Deleting hostgroups:", err)
-	//	}
-	//}
+	// Templates are unlinked before the hosts get their own items back, inherited items would have the same keys
+	err = p.UnlinkTemplates()
+	if err != nil {
+		return err
+	}
 
-	for _, host := range p.Hosts {
+	for _, host := range p.HostsAndTemplates() {
 
 		log.Infoln("Updating host:", host.Name)
 
 		applicationsByState := host.GetApplicationsByState()
 		if len(applicationsByState[StateOld]) != 0 {
 			log.Debugf("Deleting applications: %+v\n", applicationsByState[StateOld])
-			err := p.Api.ApplicationsDelete(applicationsByState[StateOld])
+			err := p.ApplicationsDelete(applicationsByState[StateOld])
 			if err != nil {
-				log.Fatalln("Deleting applications:", err)
+				return fmt.Errorf("deleting applications of host %s: %s", host.Name, err)
 			}
+			CountChanges("application", ActionDelete, len(applicationsByState[StateOld]))
 		}
 
 		if len(applicationsByState[StateNew]) != 0 {
 			log.Debugf("Creating applications: %+v\n", applicationsByState[StateNew])
-			err := p.Api.ApplicationsCreate(applicationsByState[StateNew])
+			err := p.ApplicationsCreate(applicationsByState[StateNew])
 			if err != nil {
-				log.Fatalln("Creating applications:", err)
+				return fmt.Errorf("creating applications of host %s: %s", host.Name, err)
 			}
+			CountChanges("application", ActionCreate, len(applicationsByState[StateNew]))
 		}
 		host.PropagateCreatedApplications(applicationsByState[StateNew])
 
@@ -556,51 +1263,69 @@ func (p *Provisioner) ApplyChanges() {
 
 		if len(triggersByState[StateOld]) != 0 {
 			log.Debugf("Deleting triggers: %+v\n", triggersByState[StateOld])
-			err := p.Api.TriggersDelete(triggersByState[StateOld])
+			err := p.TriggersDelete(triggersByState[StateOld])
 			if err != nil {
-				log.Fatalln("Deleting triggers:", err)
+				return fmt.Errorf("deleting triggers of host %s: %s", host.Name, err)
 			}
+			CountChanges("trigger", ActionDelete, len(triggersByState[StateOld]))
 		}
 
 		if len(itemsByState[StateOld]) != 0 {
 			log.Debugf("Deleting items: %+v\n", itemsByState[StateOld])
-			err := p.Api.ItemsDelete(itemsByState[StateOld])
+			err := p.ItemsDelete(itemsByState[StateOld])
 			if err != nil {
-				log.Fatalln("Deleting items:", err)
+				return fmt.Errorf("deleting items of host %s: %s", host.Name, err)
 			}
+			CountChanges("item", ActionDelete, len(itemsByState[StateOld]))
 		}
 
 		if len(itemsByState[StateUpdated]) != 0 {
 			log.Debugf("Updating items: %+v\n", itemsByState[StateUpdated])
-			err := p.Api.ItemsUpdate(itemsByState[StateUpdated])
+			err := p.ItemsUpdate(host, itemsByState[StateUpdated])
 			if err != nil {
-				log.Fatalln("Updating items:", err)
+				return fmt.Errorf("updating items of host %s: %s", host.Name, err)
 			}
+			CountChanges("item", ActionUpdate, len(itemsByState[StateUpdated]))
 		}
 
 		if len(triggersByState[StateUpdated]) != 0 {
 			log.Debugf("Updating triggers: %+v\n", triggersByState[StateUpdated])
-			err := p.Api.TriggersUpdate(triggersByState[StateUpdated])
+			err := p.TriggersUpdate(host, triggersByState[StateUpdated])
 			if err != nil {
-				log.Fatalln("Updating triggers:", err)
+				return fmt.Errorf("updating triggers of host %s: %s", host.Name, err)
 			}
+			CountChanges("trigger", ActionUpdate, len(triggersByState[StateUpdated]))
 		}
 
 		if len(itemsByState[StateNew]) != 0 {
 			log.Debugf("Creating items: %+v\n", itemsByState[StateNew])
-			err := p.Api.ItemsCreate(itemsByState[StateNew])
+			err := p.ItemsCreate(host, itemsByState[StateNew])
 			if err != nil {
-				log.Fatalln("Creating items:", err)
+				return fmt.Errorf("creating items of host %s: %s", host.Name, err)
 			}
+			CountChanges("item", ActionCreate, len(itemsByState[StateNew]))
 		}
 
 		if len(triggersByState[StateNew]) != 0 {
 			log.Debugf("Creating triggers: %+v\n", triggersByState[StateNew])
-			err := p.Api.TriggersCreate(triggersByState[StateNew])
+			err := p.TriggersCreate(host, triggersByState[StateNew])
 			if err != nil {
-				log.Fatalln("Creating triggers:", err)
+				return fmt.Errorf("creating triggers of host %s: %s", host.Name, err)
 			}
+			CountChanges("trigger", ActionCreate, len(triggersByState[StateNew]))
+		}
+
+		err := p.ApplyDiscoveryRules(host)
+		if err != nil {
+			return err
 		}
 	}
 
+	// Templates are linked once their items and triggers exist, and the host items they replace are deleted
+	err = p.LinkTemplates()
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
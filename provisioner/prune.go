@@ -0,0 +1,299 @@
+package provisioner
+
+import (
+	"fmt"
+	"github.com/gmauleon/zabbix-client"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Deletion of the hosts and host groups removed from the configuration
+// Managed hosts are all added to MarkerHostGroup, that's how we find the ones we own
+// Host groups have no such marker, only the ones named with HostGroupPrefix are ours (none when empty)
+type PruneConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	MarkerHostGroup string `yaml:"markerHostGroup"`
+	HostGroupPrefix string `yaml:"hostGroupPrefix"`
+	GracePeriod     int    `yaml:"gracePeriod"`
+	MaxDeletions    int    `yaml:"maxDeletions"`
+}
+
+// Get the host groups of a host, with the marker host group when pruning is enabled
+func (p *Provisioner) GetHostGroupNames(hostConfig HostConfig) []string {
+
+	if !p.Config.Prune.Enabled {
+		return hostConfig.HostGroups
+	}
+
+	return append(append([]string{}, hostConfig.HostGroups...), p.Config.Prune.MarkerHostGroup)
+}
+
+// Find the hosts we own that are not configured anymore for longer than the grace period,
+// along with the host groups that would be left empty by their deletion
+func (p *Provisioner) GetPruneCandidates() (zabbix.Hosts, zabbix.HostGroups, error) {
+
+	markerHostGroups, err := p.HostGroupsGet(zabbix.Params{
+		"output": "extend",
+		"filter": map[string][]string{
+			"name": {p.Config.Prune.MarkerHostGroup},
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting marker host group: %s", err)
+	}
+
+	if len(markerHostGroups) == 0 {
+		return zabbix.Hosts{}, zabbix.HostGroups{}, nil
+	}
+
+	ownedHosts, err := p.HostsGet(zabbix.Params{
+		"output":   "extend",
+		"groupids": markerHostGroups[0].GroupId,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting hosts of the marker host group: %s", err)
+	}
+
+	configuredHosts := map[string]struct{}{}
+	configuredHostGroups := map[string]struct{}{
+		p.Config.Prune.MarkerHostGroup: {},
+		p.Config.TemplateGroup:         {},
+	}
+	for _, hostConfig := range p.HostConfigs {
+		configuredHosts[hostConfig.Name] = struct{}{}
+		for _, hostGroupName := range hostConfig.HostGroups {
+			configuredHostGroups[hostGroupName] = struct{}{}
+		}
+	}
+
+	now := time.Now()
+	gracePeriod := time.Duration(p.Config.Prune.GracePeriod) * time.Second
+
+	orphans := map[string]struct{}{}
+	hosts := zabbix.Hosts{}
+	for _, host := range ownedHosts {
+		if _, ok := configuredHosts[host.Host]; ok {
+			continue
+		}
+		orphans[host.Host] = struct{}{}
+
+		since, ok := p.OrphanHosts[host.Host]
+		if !ok {
+			since = now
+			p.OrphanHosts[host.Host] = since
+			log.Infof("Host %s is not configured anymore, it will be deleted after %s", host.Host, gracePeriod)
+		}
+
+		if now.Sub(since) >= gracePeriod {
+			hosts = append(hosts, host)
+		}
+	}
+
+	// Forget hosts configured again or already deleted
+	for hostName := range p.OrphanHosts {
+		if _, ok := orphans[hostName]; !ok {
+			delete(p.OrphanHosts, hostName)
+		}
+	}
+
+	hostGroups := zabbix.HostGroups{}
+	if len(hosts) == 0 {
+		return hosts, hostGroups, nil
+	}
+
+	deletedHostIds := make(map[string]struct{}, len(hosts))
+	hostIds := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		deletedHostIds[host.HostId] = struct{}{}
+		hostIds = append(hostIds, host.HostId)
+	}
+
+	candidateHostGroups, err := p.HostGroupsGet(zabbix.Params{
+		"output":  "extend",
+		"hostids": hostIds,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting host groups of deleted hosts: %s", err)
+	}
+
+	for _, hostGroup := range candidateHostGroups {
+		if _, ok := configuredHostGroups[hostGroup.Name]; ok {
+			continue
+		}
+
+		if !p.IsOwnedHostGroup(hostGroup) {
+			log.Debugf("Keeping host group not managed by the provisioner: %s", hostGroup.Name)
+			continue
+		}
+
+		groupHosts, err := p.HostsGet(zabbix.Params{
+			"output":   []string{"hostid"},
+			"groupids": hostGroup.GroupId,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting hosts of host group %s: %s", hostGroup.Name, err)
+		}
+
+		// Only delete groups that contain nothing but hosts being deleted
+		empty := true
+		for _, groupHost := range groupHosts {
+			if _, ok := deletedHostIds[groupHost.HostId]; !ok {
+				empty = false
+				break
+			}
+		}
+
+		if !empty {
+			continue
+		}
+
+		// Host groups hold templates too before Zabbix 6.2
+		if !p.UseTemplateGroups() {
+			count, err := p.TemplatesCount(hostGroup.GroupId)
+			if err != nil {
+				return nil, nil, fmt.Errorf("getting templates of host group %s: %s", hostGroup.Name, err)
+			}
+			if count != 0 {
+				continue
+			}
+		}
+
+		hostGroups = append(hostGroups, hostGroup)
+	}
+
+	return hosts, hostGroups, nil
+}
+
+// Check if a host group is ours, from its name
+func (p *Provisioner) IsOwnedHostGroup(hostGroup zabbix.HostGroup) bool {
+	prefix := p.Config.Prune.HostGroupPrefix
+	return len(prefix) != 0 && strings.HasPrefix(hostGroup.Name, prefix)
+}
+
+// Find the hosts and host groups to prune in this cycle, with the managed items and triggers deleted along with
+// the hosts, so that the deletion guard counts them
+func (p *Provisioner) FillPruneCandidates() error {
+
+	p.PruneHosts, p.PruneHostGroups, p.PruneObjects = nil, nil, 0
+	if !p.Config.Prune.Enabled {
+		return nil
+	}
+
+	hosts, hostGroups, err := p.GetPruneCandidates()
+	if err != nil {
+		return err
+	}
+
+	objects, err := p.CountOwnedObjects(hosts)
+	if err != nil {
+		return err
+	}
+
+	p.PruneHosts, p.PruneHostGroups, p.PruneObjects = hosts, hostGroups, objects
+	return nil
+}
+
+// Count the managed items and triggers of hosts
+func (p *Provisioner) CountOwnedObjects(hosts zabbix.Hosts) (int, error) {
+
+	if len(hosts) == 0 {
+		return 0, nil
+	}
+
+	hostIds := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		hostIds = append(hostIds, host.HostId)
+	}
+
+	items, err := p.ItemsGet(zabbix.Params{
+		"output":    []string{"itemid", "key_"},
+		"hostids":   hostIds,
+		"inherited": false,
+		"filter":    map[string]string{"flags": "0"},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("getting items of pruned hosts: %s", err)
+	}
+
+	triggers, err := p.TriggersGet(zabbix.Params{
+		"output":           []string{"triggerid", "expression"},
+		"hostids":          hostIds,
+		"expandExpression": true,
+		"inherited":        false,
+		"filter":           map[string]string{"flags": "0"},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("getting triggers of pruned hosts: %s", err)
+	}
+
+	count := 0
+	for _, item := range items {
+		if p.IsOwnedItem(item) {
+			count++
+		}
+	}
+	for _, trigger := range triggers {
+		if p.IsOwnedTrigger(trigger) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Abort the cycle before any change if it would prune more than MaxDeletions hosts and host groups
+func (p *Provisioner) CheckPrune() error {
+
+	err := p.GuardPrune()
+	if err != nil {
+		PruneGuardTriggered.Inc()
+		log.Errorf("PRUNE GUARD: %s, nothing was changed in Zabbix. Check your configuration, or raise prune.maxDeletions if that's intended", err)
+		return err
+	}
+
+	return nil
+}
+
+// Check the hosts and host groups found by FillPruneCandidates against MaxDeletions
+func (p *Provisioner) GuardPrune() error {
+
+	hosts, hostGroups := p.PruneHosts, p.PruneHostGroups
+
+	if len(hosts)+len(hostGroups) > p.Config.Prune.MaxDeletions {
+		return fmt.Errorf("pruning %d hosts and %d host groups is more than the %d deletions allowed in one cycle",
+			len(hosts), len(hostGroups), p.Config.Prune.MaxDeletions)
+	}
+
+	return nil
+}
+
+// Delete the hosts and host groups found by FillPruneCandidates, once CheckPrune passed
+func (p *Provisioner) Prune() error {
+
+	hosts, hostGroups := p.PruneHosts, p.PruneHostGroups
+
+	if len(hosts) != 0 {
+		log.Infof("Deleting Hosts: %+v\n", hosts)
+		err := p.HostsDelete(hosts)
+		if err != nil {
+			return fmt.Errorf("deleting hosts: %s", err)
+		}
+		CountChanges("host", ActionDelete, len(hosts))
+
+		for _, host := range hosts {
+			delete(p.OrphanHosts, host.Host)
+		}
+	}
+
+	if len(hostGroups) != 0 {
+		log.Infof("Deleting HostGroups: %+v\n", hostGroups)
+		err := p.HostGroupsDelete(hostGroups)
+		if err != nil {
+			return fmt.Errorf("deleting hostgroups: %s", err)
+		}
+		CountChanges("hostgroup", ActionDelete, len(hostGroups))
+	}
+
+	return nil
+}
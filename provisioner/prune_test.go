@@ -0,0 +1,259 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"github.com/gmauleon/zabbix-client"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Serve the Zabbix API, answering each method with the result of its handler
+// Objects sent in an array by the create and update methods are given one by one, their ids are merged
+// A *zabbix.Error result is answered as an API error
+func newZabbixServer(t *testing.T, handlers map[string]func(params map[string]interface{}) interface{}) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Id     int32           `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("can't decode request: %s", err)
+		}
+
+		handler, ok := handlers[req.Method]
+		if !ok {
+			t.Errorf("unexpected call to %s", req.Method)
+			handler = func(map[string]interface{}) interface{} { return []interface{}{} }
+		}
+
+		var result interface{}
+		var objects []map[string]interface{}
+		if err := json.Unmarshal(req.Params, &objects); err == nil {
+			ids := map[string][]string{}
+			for _, object := range objects {
+				for key, objectIds := range handler(object).(map[string][]string) {
+					ids[key] = append(ids[key], objectIds...)
+				}
+			}
+			result = ids
+		} else {
+			var params map[string]interface{}
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				t.Errorf("can't decode params of %s: %s", req.Method, err)
+			}
+			result = handler(params)
+		}
+		if err, ok := result.(*zabbix.Error); ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "error": err, "id": req.Id})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "id": req.Id})
+	}))
+}
+
+// Zabbix with the marker host group "managed" holding hosts a (configured), b and c, and host groups:
+// "prom-b" with b only, "prom-bc" with b and c, "prom-shared" with a and b, "other" with b
+func newPruneServer(t *testing.T) *httptest.Server {
+
+	groupHosts := map[string][]string{
+		"1": {"a", "b", "c"},
+		"2": {"b"},
+		"3": {"b", "c"},
+		"4": {"a", "b"},
+		"5": {"b"},
+	}
+	groups := zabbix.HostGroups{
+		{GroupId: "1", Name: "managed"},
+		{GroupId: "2", Name: "prom-b"},
+		{GroupId: "3", Name: "prom-bc"},
+		{GroupId: "4", Name: "prom-shared"},
+		{GroupId: "5", Name: "other"},
+	}
+
+	return newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+		"hostgroup.get": func(params map[string]interface{}) interface{} {
+			if filter, ok := params["filter"]; ok {
+				name := filter.(map[string]interface{})["name"].([]interface{})[0]
+				for _, group := range groups {
+					if group.Name == name {
+						return zabbix.HostGroups{group}
+					}
+				}
+				return zabbix.HostGroups{}
+			}
+
+			// Groups of the given hosts
+			result := zabbix.HostGroups{}
+			for _, group := range groups[1:] {
+			hosts:
+				for _, host := range groupHosts[group.GroupId] {
+					for _, hostId := range params["hostids"].([]interface{}) {
+						if host == hostId {
+							result = append(result, group)
+							break hosts
+						}
+					}
+				}
+			}
+			return result
+		},
+		"host.get": func(params map[string]interface{}) interface{} {
+			hosts := zabbix.Hosts{}
+			for _, name := range groupHosts[params["groupids"].(string)] {
+				hosts = append(hosts, zabbix.Host{HostId: name, Host: name, Name: name})
+			}
+			return hosts
+		},
+	})
+}
+
+func TestGetPruneCandidates(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		gracePeriod int
+		orphanSince map[string]time.Duration
+		hosts       []string
+		hostGroups  []string
+	}{
+		{name: "no grace period", hosts: []string{"b", "c"}, hostGroups: []string{"prom-b", "prom-bc"}},
+		{name: "within the grace period", gracePeriod: 3600, hosts: []string{}, hostGroups: []string{}},
+		{name: "grace period over for one host", gracePeriod: 3600, orphanSince: map[string]time.Duration{"b": 2 * time.Hour},
+			hosts: []string{"b"}, hostGroups: []string{"prom-b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newPruneServer(t)
+			defer server.Close()
+
+			p := &Provisioner{
+				Api: zabbix.NewAPI(server.URL),
+				Config: ProvisionerConfig{Prune: PruneConfig{
+					Enabled:         true,
+					MarkerHostGroup: "managed",
+					HostGroupPrefix: "prom-",
+					GracePeriod:     test.gracePeriod,
+				}},
+				HostConfigs:   []HostConfig{{Name: "a", HostGroups: []string{"prom-shared"}}},
+				OrphanHosts:   map[string]time.Time{"gone": time.Now()},
+				ZabbixVersion: &ZabbixVersion{Major: 6, Minor: 2},
+			}
+			for name, since := range test.orphanSince {
+				p.OrphanHosts[name] = time.Now().Add(-since)
+			}
+
+			hosts, hostGroups, err := p.GetPruneCandidates()
+			if err != nil {
+				t.Fatalf("GetPruneCandidates: %s", err)
+			}
+
+			hostNames := []string{}
+			for _, host := range hosts {
+				hostNames = append(hostNames, host.Host)
+			}
+			hostGroupNames := []string{}
+			for _, hostGroup := range hostGroups {
+				hostGroupNames = append(hostGroupNames, hostGroup.Name)
+			}
+			sort.Strings(hostNames)
+			sort.Strings(hostGroupNames)
+
+			if !reflect.DeepEqual(hostNames, test.hosts) {
+				t.Errorf("expected hosts %v, got %v", test.hosts, hostNames)
+			}
+			if !reflect.DeepEqual(hostGroupNames, test.hostGroups) {
+				t.Errorf("expected host groups %v, got %v", test.hostGroups, hostGroupNames)
+			}
+
+			// Unconfigured hosts wait for their grace period, the others are forgotten
+			if _, ok := p.OrphanHosts["gone"]; ok {
+				t.Error("expected a host not in Zabbix anymore to be forgotten")
+			}
+			if _, ok := p.OrphanHosts["c"]; !ok {
+				t.Error("expected host c to be waiting for its grace period")
+			}
+		})
+	}
+}
+
+func TestCheckDeletionsPrunedHosts(t *testing.T) {
+
+	server := newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+		"item.get": func(params map[string]interface{}) interface{} {
+			return zabbix.Items{
+				{ItemId: "1", Key: "prometheus.nodedown"},
+				{ItemId: "2", Key: "prometheus.diskfull"},
+				{ItemId: "3", Key: "agent.ping"},
+			}
+		},
+		"trigger.get": func(params map[string]interface{}) interface{} {
+			return zabbix.Triggers{
+				{TriggerId: "1", Expression: "{b:prometheus.nodedown.last()}<>0"},
+				{TriggerId: "2", Expression: "{b:agent.ping.nodata(5m)}=1"},
+			}
+		},
+	})
+	defer server.Close()
+
+	p := &Provisioner{
+		Api: zabbix.NewAPI(server.URL),
+		Config: ProvisionerConfig{
+			ZabbixKeyPrefix: "prometheus",
+			DeletionGuard:   DeletionGuardConfig{MaxDeletionPercent: 50},
+		},
+		CustomZabbix: newGuardZabbix(2, 0),
+	}
+
+	count, err := p.CountOwnedObjects(zabbix.Hosts{{HostId: "b", Host: "b"}})
+	if err != nil {
+		t.Fatalf("CountOwnedObjects: %s", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 managed items and triggers, got %d", count)
+	}
+
+	// Nothing is deleted on the configured hosts, but all the objects of the pruned host are
+	p.PruneObjects = count
+	if err := p.CheckDeletions(); err == nil {
+		t.Error("expected the cycle to be aborted")
+	}
+}
+
+func TestCheckPrune(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		maxDeletions int
+		fail         bool
+	}{
+		{name: "under the limit", maxDeletions: 3},
+		{name: "at the limit", maxDeletions: 2},
+		{name: "over the limit", maxDeletions: 1, fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{
+				Config:          ProvisionerConfig{Prune: PruneConfig{Enabled: true, MaxDeletions: test.maxDeletions}},
+				PruneHosts:      zabbix.Hosts{{HostId: "b", Host: "b"}},
+				PruneHostGroups: zabbix.HostGroups{{GroupId: "2", Name: "prom-b"}},
+			}
+
+			// Checked before any change, the cycle aborts with nothing applied
+			err := p.CheckPrune()
+			if test.fail && err == nil {
+				t.Error("expected the cycle to be aborted")
+			}
+			if !test.fail && err != nil {
+				t.Errorf("expected the cycle to go on, got %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package provisioner
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff with jitter, between Initial and Max
+type Backoff struct {
+	Initial  time.Duration
+	Max      time.Duration
+	attempts int
+}
+
+func NewBackoff(initial time.Duration, max time.Duration) *Backoff {
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max < initial {
+		max = initial
+	}
+	return &Backoff{Initial: initial, Max: max}
+}
+
+// Get the next delay, doubling it on every call up to Max
+// The delay is randomized between half and all of it, so many instances don't retry in sync
+func (b *Backoff) Next() time.Duration {
+
+	delay := b.Max
+	if b.attempts < 32 {
+		delay = b.Initial << uint(b.attempts)
+		if delay <= 0 || delay > b.Max {
+			delay = b.Max
+		}
+	}
+	b.attempts++
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
+
+// Count consecutive failures, the circuit opens once Threshold is reached and closes on the next success
+type CircuitBreaker struct {
+	Threshold int
+	failures  int
+}
+
+func NewCircuitBreaker(threshold int) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	return &CircuitBreaker{Threshold: threshold}
+}
+
+func (c *CircuitBreaker) Success() {
+	c.failures = 0
+}
+
+func (c *CircuitBreaker) Failure() {
+	c.failures++
+}
+
+func (c *CircuitBreaker) Failures() int {
+	return c.failures
+}
+
+func (c *CircuitBreaker) Open() bool {
+	return c.failures >= c.Threshold
+}
@@ -0,0 +1,72 @@
+package provisioner
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+
+	backoff := NewBackoff(time.Second, 10*time.Second)
+
+	// Each delay is randomized between half and all of 1s, 2s, 4s, 8s, then 10s
+	for _, full := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		full *= time.Second
+		if delay := backoff.Next(); delay < full/2 || delay > full {
+			t.Errorf("expected a delay between %s and %s, got %s", full/2, full, delay)
+		}
+	}
+
+	backoff.Reset()
+	if delay := backoff.Next(); delay > time.Second {
+		t.Errorf("expected the delay to start again from 1s, got %s", delay)
+	}
+}
+
+func TestNewBackoffDefaults(t *testing.T) {
+
+	backoff := NewBackoff(0, 0)
+	if backoff.Initial != time.Second || backoff.Max != time.Second {
+		t.Errorf("expected 1s initial and max delays, got %s and %s", backoff.Initial, backoff.Max)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	breaker := NewCircuitBreaker(3)
+
+	for i := 1; i <= 3; i++ {
+		if breaker.Open() {
+			t.Fatalf("expected the circuit to be closed after %d failures", i-1)
+		}
+		breaker.Failure()
+	}
+
+	if !breaker.Open() || breaker.Failures() != 3 {
+		t.Fatalf("expected the circuit to be open after 3 failures, got %d", breaker.Failures())
+	}
+
+	breaker.Success()
+	if breaker.Open() || breaker.Failures() != 0 {
+		t.Errorf("expected the circuit to close on success")
+	}
+}
+
+func TestReconcileError(t *testing.T) {
+
+	p := &Provisioner{
+		Config:      ProvisionerConfig{ZabbixApiVersion: "6.0"},
+		RuleSources: []RuleSource{&namedRuleSource{name: "prometheus", err: errors.New("connection refused")}},
+		Status:      &Status{},
+	}
+
+	// A failing cycle returns its error instead of exiting, and the provisioner is not ready
+	err := p.Reconcile()
+	if err == nil || err.Error() != "reading rules: rule source prometheus: connection refused" {
+		t.Errorf("expected the rule source error, got %v", err)
+	}
+	if p.Status.IsReady() {
+		t.Error("expected the provisioner not to be ready")
+	}
+}
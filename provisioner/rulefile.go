@@ -0,0 +1,271 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Standard Prometheus rule file, as loaded by the rule_files directive
+type RuleGroupsFile struct {
+	Groups []RuleGroup `yaml:"groups" json:"groups"`
+}
+
+type RuleGroup struct {
+	Name     string          `yaml:"name" json:"name"`
+	Interval string          `yaml:"interval" json:"interval"`
+	Rules    []RuleGroupRule `yaml:"rules" json:"rules"`
+}
+
+type RuleGroupRule struct {
+	Alert       string            `yaml:"alert" json:"alert"`
+	Record      string            `yaml:"record" json:"record"`
+	Expr        RuleExpr          `yaml:"expr" json:"expr"`
+	For         string            `yaml:"for" json:"for"`
+	Labels      map[string]string `yaml:"labels" json:"labels"`
+	Annotations map[string]string `yaml:"annotations" json:"annotations"`
+}
+
+// Rule expression, Kubernetes objects allow them to be numbers
+type RuleExpr string
+
+func (e *RuleExpr) UnmarshalJSON(data []byte) error {
+
+	var expr interface{}
+	err := json.Unmarshal(data, &expr)
+	if err != nil {
+		return err
+	}
+
+	switch value := expr.(type) {
+	case string:
+		*e = RuleExpr(value)
+	case nil:
+		*e = ""
+	default:
+		*e = RuleExpr(strings.TrimSpace(string(data)))
+	}
+
+	return nil
+}
+
+// Rules read from Prometheus rule files on disk
+type FileRuleSource struct {
+	SourceName    string
+	Paths         []string
+	WatchInterval time.Duration
+}
+
+func (s *FileRuleSource) Name() string {
+	return s.SourceName
+}
+
+func (s *FileRuleSource) GetRules() ([]PrometheusRule, error) {
+
+	files, err := s.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []PrometheusRule{}
+	for _, file := range files {
+		fileRules, err := GetRulesFromFile(file)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	return rules, nil
+}
+
+// List the rule files matching the configured paths, a directory includes all its .yml and .yaml files
+func (s *FileRuleSource) Files() ([]string, error) {
+
+	found := map[string]struct{}{}
+
+	for _, path := range s.Paths {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule files pattern %s: %s", path, err)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, fmt.Errorf("can't read rule file %s: %s", match, err)
+			}
+
+			if !info.IsDir() {
+				found[match] = struct{}{}
+				continue
+			}
+
+			for _, pattern := range []string{"*.yml", "*.yaml"} {
+				dirMatches, _ := filepath.Glob(filepath.Join(match, pattern))
+				for _, dirMatch := range dirMatches {
+					found[dirMatch] = struct{}{}
+				}
+			}
+		}
+	}
+
+	files := make([]string, 0, len(found))
+	for file := range found {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// Poll the rule files and notify when one of them is added, removed or modified
+func (s *FileRuleSource) Watch(changed chan<- struct{}, stop <-chan struct{}) {
+
+	ticker := time.NewTicker(s.WatchInterval)
+	defer ticker.Stop()
+
+	last := s.filesState()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := s.filesState()
+			if current != last {
+				log.Infof("Rule files of source %s changed", s.SourceName)
+				last = current
+				Notify(changed)
+			}
+		}
+	}
+}
+
+// Summarize names, sizes and modification times of the rule files
+func (s *FileRuleSource) filesState() string {
+
+	files, err := s.Files()
+	if err != nil {
+		log.Warnf("Can't list rule files of source %s: %s", s.SourceName, err)
+		return ""
+	}
+
+	var state strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&state, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return state.String()
+}
+
+// Get alerting rules from a Prometheus rule file
+func GetRulesFromFile(filename string) ([]PrometheusRule, error) {
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("can't open the rule file: %s", err)
+	}
+
+	ruleFile := RuleGroupsFile{}
+	err = yaml.Unmarshal(content, &ruleFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the rule file %s: %s", filename, err)
+	}
+
+	rules := []PrometheusRule{}
+	for _, group := range ruleFile.Groups {
+		groupRules, err := group.AlertingRules()
+		if err != nil {
+			return nil, fmt.Errorf("rule file %s: %s", filename, err)
+		}
+		rules = append(rules, groupRules...)
+	}
+
+	return rules, nil
+}
+
+// Convert the alerting rules of a group, recording rules are skipped
+func (g *RuleGroup) AlertingRules() ([]PrometheusRule, error) {
+
+	rules := []PrometheusRule{}
+
+	for _, groupRule := range g.Rules {
+		if len(groupRule.Alert) == 0 {
+			continue
+		}
+
+		duration, err := ParsePrometheusDuration(groupRule.For)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s in group %s: %s", groupRule.Alert, g.Name, err)
+		}
+
+		rule := PrometheusRule{
+			Name:        groupRule.Alert,
+			Group:       g.Name,
+			Query:       string(groupRule.Expr),
+			Duration:    duration,
+			Labels:      groupRule.Labels,
+			Annotations: groupRule.Annotations,
+		}
+
+		if rule.Labels == nil {
+			rule.Labels = map[string]string{}
+		}
+		if rule.Annotations == nil {
+			rule.Annotations = map[string]string{}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+var durationRegexp = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?(?:(\d+)ms)?$`)
+
+// Parse a duration using the Prometheus syntax (1d2h, 5m, 30s...), an empty duration is 0
+func ParsePrometheusDuration(duration string) (time.Duration, error) {
+
+	if len(duration) == 0 || duration == "0" {
+		return 0, nil
+	}
+
+	matches := durationRegexp.FindStringSubmatch(duration)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid duration '%s'", duration)
+	}
+
+	units := []time.Duration{
+		365 * 24 * time.Hour,
+		7 * 24 * time.Hour,
+		24 * time.Hour,
+		time.Hour,
+		time.Minute,
+		time.Second,
+		time.Millisecond,
+	}
+
+	var result time.Duration
+	for i, unit := range units {
+		if len(matches[i+1]) == 0 {
+			continue
+		}
+		value, _ := strconv.Atoi(matches[i+1])
+		result += time.Duration(value) * unit
+	}
+
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package provisioner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePrometheusDuration(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		duration string
+		expected time.Duration
+		valid    bool
+	}{
+		{name: "empty", duration: "", expected: 0, valid: true},
+		{name: "zero", duration: "0", expected: 0, valid: true},
+		{name: "seconds", duration: "30s", expected: 30 * time.Second, valid: true},
+		{name: "minutes", duration: "5m", expected: 5 * time.Minute, valid: true},
+		{name: "milliseconds", duration: "500ms", expected: 500 * time.Millisecond, valid: true},
+		{name: "days", duration: "1d", expected: 24 * time.Hour, valid: true},
+		{name: "weeks", duration: "2w", expected: 14 * 24 * time.Hour, valid: true},
+		{name: "years", duration: "1y", expected: 365 * 24 * time.Hour, valid: true},
+		{name: "combined", duration: "1d2h30m", expected: 26*time.Hour + 30*time.Minute, valid: true},
+		{name: "minutes and milliseconds", duration: "1m500ms", expected: time.Minute + 500*time.Millisecond, valid: true},
+		{name: "no unit", duration: "30", valid: false},
+		{name: "unknown unit", duration: "30x", valid: false},
+		{name: "wrong order", duration: "30m1h", valid: false},
+		{name: "fraction", duration: "1.5h", valid: false},
+		{name: "negative", duration: "-5m", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			duration, err := ParsePrometheusDuration(test.duration)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", duration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if duration != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, duration)
+			}
+		})
+	}
+}
+
+func TestAlertingRules(t *testing.T) {
+
+	group := RuleGroup{
+		Name: "nodes",
+		Rules: []RuleGroupRule{
+			{Record: "node:up:sum", Expr: "sum(up)"},
+			{Alert: "NodeDown", Expr: "up == 0", For: "5m", Labels: map[string]string{"severity": "critical"}},
+			{Alert: "NodeRebooted", Expr: "changes(node_boot_time_seconds[10m]) > 0"},
+		},
+	}
+
+	rules, err := group.AlertingRules()
+	if err != nil {
+		t.Fatalf("AlertingRules: %s", err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 alerting rules, got %d", len(rules))
+	}
+
+	if rules[0].Name != "NodeDown" || rules[0].Group != "nodes" || rules[0].Duration != 5*time.Minute {
+		t.Errorf("unexpected rule %+v", rules[0])
+	}
+
+	if rules[1].Labels == nil || rules[1].Annotations == nil {
+		t.Errorf("expected empty labels and annotations, got %+v", rules[1])
+	}
+
+	group.Rules = append(group.Rules, RuleGroupRule{Alert: "DiskFull", For: "5 minutes"})
+	if _, err := group.AlertingRules(); err == nil {
+		t.Errorf("expected an error for an invalid duration")
+	}
+}
+
+func writeRuleFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("can't write %s: %s", path, err)
+	}
+}
+
+func TestFileRuleSourceFiles(t *testing.T) {
+
+	dir := t.TempDir()
+	rulesDir := filepath.Join(dir, "rules.d")
+	if err := os.Mkdir(rulesDir, 0755); err != nil {
+		t.Fatalf("can't create %s: %s", rulesDir, err)
+	}
+	for _, name := range []string{"nodes.yml", "disks.yaml", "README.md", "nodes.yml.bak"} {
+		writeRuleFile(t, filepath.Join(rulesDir, name), "groups: []")
+	}
+	writeRuleFile(t, filepath.Join(dir, "apps.rules"), "groups: []")
+	writeRuleFile(t, filepath.Join(dir, "infra.rules"), "groups: []")
+
+	// Directories only keep their rule files, globs keep any file they match
+	s := &FileRuleSource{Paths: []string{rulesDir, filepath.Join(dir, "*.rules"), filepath.Join(dir, "apps.rules"), filepath.Join(dir, "missing*.yml")}}
+	files, err := s.Files()
+	if err != nil {
+		t.Fatalf("Files: %s", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "apps.rules"),
+		filepath.Join(dir, "infra.rules"),
+		filepath.Join(rulesDir, "disks.yaml"),
+		filepath.Join(rulesDir, "nodes.yml"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected files %v, got %v", expected, files)
+	}
+}
+
+func TestFileRuleSourceWatch(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nodes.yml")
+	writeRuleFile(t, path, "groups: []")
+
+	s := &FileRuleSource{SourceName: "files", Paths: []string{dir}, WatchInterval: 10 * time.Millisecond}
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Watch(changed, stop)
+
+	select {
+	case <-changed:
+		t.Fatal("expected no notification for unchanged files")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	writeRuleFile(t, path, "groups:\n- name: nodes\n  rules: []\n")
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the changed rule file to be notified")
+	}
+}
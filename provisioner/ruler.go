@@ -0,0 +1,122 @@
+package provisioner
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"sort"
+)
+
+const DefaultTenantHeader = "X-Scope-OrgID"
+
+// Rules read from a Cortex/Mimir ruler config API or from a Thanos Ruler, for one or several tenants
+type RulerRuleSource struct {
+	SourceName   string
+	Url          string
+	Thanos       bool
+	Tenants      []string
+	TenantHeader string
+	Client       *http.Client
+}
+
+func (s *RulerRuleSource) Name() string {
+	return s.SourceName
+}
+
+func (s *RulerRuleSource) GetRules() ([]PrometheusRule, error) {
+
+	// Without tenants, the ruler is queried as is (single tenant setup or tenant added by a proxy)
+	tenants := s.Tenants
+	if len(tenants) == 0 {
+		tenants = []string{""}
+	}
+
+	rules := []PrometheusRule{}
+	for _, tenant := range tenants {
+		client := s.Client
+		if len(tenant) != 0 {
+			client = &http.Client{
+				Transport: &authRoundTripper{
+					headers: map[string]string{s.TenantHeader: tenant},
+					next:    s.Client.Transport,
+				},
+			}
+		}
+
+		var tenantRules []PrometheusRule
+		var err error
+		if s.Thanos {
+			tenantRules, err = GetRulesFromAPI(client, s.Url)
+		} else {
+			tenantRules, err = GetRulesFromRulerConfig(client, s.Url)
+		}
+
+		if err != nil {
+			if len(tenant) != 0 {
+				return nil, fmt.Errorf("tenant %s: %s", tenant, err)
+			}
+			return nil, err
+		}
+
+		for i := range tenantRules {
+			tenantRules[i].Tenant = tenant
+		}
+		rules = append(rules, tenantRules...)
+	}
+
+	return rules, nil
+}
+
+// Get alerting rules from the Cortex/Mimir ruler config API, which returns rule groups by namespace
+func GetRulesFromRulerConfig(client *http.Client, url string) ([]PrometheusRule, error) {
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("can't get rules from %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	// The ruler answers 404 when a tenant does not have any rule
+	if resp.StatusCode == http.StatusNotFound {
+		return []PrometheusRule{}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't get rules from %s: unexpected status %s", url, resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't get rules from %s: %s", url, err)
+	}
+
+	groupsByNamespace := map[string][]RuleGroup{}
+	err = yaml.Unmarshal(content, &groupsByNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode rules from %s: %s", url, err)
+	}
+
+	namespaces := make([]string, 0, len(groupsByNamespace))
+	for namespace := range groupsByNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	rules := []PrometheusRule{}
+	for _, namespace := range namespaces {
+		for _, group := range groupsByNamespace[namespace] {
+			groupRules, err := group.AlertingRules()
+			if err != nil {
+				return nil, fmt.Errorf("namespace %s: %s", namespace, err)
+			}
+
+			for i := range groupRules {
+				groupRules[i].Namespace = namespace
+			}
+			rules = append(rules, groupRules...)
+		}
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,107 @@
+package provisioner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRulerRuleSource(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+			t.Errorf("expected the basic auth of the source, got %q", r.Header.Get("Authorization"))
+		}
+
+		switch r.Header.Get("X-Scope-OrgID") {
+		case "team-a":
+			fmt.Fprint(w, `
+node:
+  - name: node
+    rules:
+      - record: node:up:sum
+        expr: sum(up)
+      - alert: NodeDown
+        expr: up == 0
+        labels:
+          severity: critical
+disk:
+  - name: disk
+    rules:
+      - alert: DiskFull
+        expr: disk_free == 0
+`)
+		case "team-b":
+			// No rule for that tenant
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	source, err := NewRuleSource(RuleSourceConfig{
+		Name:      "mimir",
+		Type:      RuleSourceMimir,
+		Url:       server.URL + "/prometheus/config/v1/rules",
+		BasicAuth: &BasicAuthConfig{Username: "user", Password: "secret"},
+		Tenants:   []string{"team-a", "team-b"},
+	})
+	if err != nil {
+		t.Fatalf("NewRuleSource: %s", err)
+	}
+
+	rules, err := source.GetRules()
+	if err != nil {
+		t.Fatalf("GetRules: %s", err)
+	}
+
+	names := []string{}
+	for _, rule := range rules {
+		names = append(names, rule.Tenant+"/"+rule.Namespace+"/"+rule.Group+"/"+rule.Name)
+	}
+	expected := []string{"team-a/disk/disk/DiskFull", "team-a/node/node/NodeDown"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected rules %v, got %v", expected, names)
+	}
+}
+
+func TestRulerRuleSourceThanos(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant := r.Header.Get("THANOS-TENANT"); tenant != "team-a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"status": "success", "data": {"groups": [{"name": "node", "rules": [{"type": "alerting", "name": "NodeDown", "query": "up == 0"}]}]}}`)
+	}))
+	defer server.Close()
+
+	source, err := NewRuleSource(RuleSourceConfig{
+		Name:         "thanos",
+		Type:         RuleSourceThanos,
+		Url:          server.URL + "/api/v1/rules",
+		Tenants:      []string{"team-a"},
+		TenantHeader: "THANOS-TENANT",
+	})
+	if err != nil {
+		t.Fatalf("NewRuleSource: %s", err)
+	}
+
+	rules, err := source.GetRules()
+	if err != nil {
+		t.Fatalf("GetRules: %s", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "NodeDown" || rules[0].Tenant != "team-a" {
+		t.Errorf("expected NodeDown of team-a, got %+v", rules)
+	}
+
+	// A failing tenant fails the whole source
+	source.(*RulerRuleSource).Tenants = []string{"team-a", "team-c"}
+	if _, err := source.GetRules(); err == nil || !strings.HasPrefix(err.Error(), "tenant team-c:") {
+		t.Errorf("expected an error for tenant team-c, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package provisioner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+	MatchExists    = "exists"
+	MatchNotExists = "notexists"
+	MatchIn        = "in"
+	MatchNotIn     = "notin"
+)
+
+// A condition on an annotation or a label of a rule
+// A missing key is handled as an empty value by the =, !=, =~ and !~ operators, like in PromQL
+type Matcher struct {
+	Key      string   `yaml:"key"`
+	Operator string   `yaml:"operator"`
+	Value    string   `yaml:"value"`
+	Values   []string `yaml:"values"`
+
+	regexp *regexp.Regexp
+}
+
+// Validate the matcher and compile its regular expression if any
+func (m *Matcher) Compile() error {
+
+	if len(m.Key) == 0 {
+		return fmt.Errorf("matcher without key")
+	}
+
+	// "not exists" and "not in" are accepted as well
+	m.Operator = strings.ToLower(strings.Replace(m.Operator, " ", "", -1))
+
+	switch m.Operator {
+	case MatchEqual, MatchNotEqual, MatchExists, MatchNotExists:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("matcher on %s: invalid regular expression '%s': %s", m.Key, m.Value, err)
+		}
+		m.regexp = re
+	case MatchIn, MatchNotIn:
+		if len(m.Values) == 0 {
+			return fmt.Errorf("matcher on %s: operator %s needs a list of values", m.Key, m.Operator)
+		}
+	default:
+		return fmt.Errorf("matcher on %s: unknown operator '%s'", m.Key, m.Operator)
+	}
+
+	return nil
+}
+
+// Check the matcher against the annotations or the labels of a rule
+func (m *Matcher) Matches(values map[string]string) bool {
+
+	if m.regexp == nil && (m.Operator == MatchRegexp || m.Operator == MatchNotRegexp) {
+		if err := m.Compile(); err != nil {
+			return false
+		}
+	}
+
+	value, ok := values[m.Key]
+
+	switch m.Operator {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.regexp.MatchString(value)
+	case MatchNotRegexp:
+		return !m.regexp.MatchString(value)
+	case MatchExists:
+		return ok
+	case MatchNotExists:
+		return !ok
+	case MatchIn:
+		return ok && IsMatchingList(m.Values, value)
+	case MatchNotIn:
+		return !ok || !IsMatchingList(m.Values, value)
+	default:
+		return false
+	}
+}
+
+// Check if all the matchers match, an empty list always matches
+func IsMatchingMatchers(matchers []Matcher, values map[string]string) bool {
+
+	for i := range matchers {
+		if !matchers[i].Matches(values) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package provisioner
+
+import (
+	"testing"
+)
+
+func TestMatcherMatches(t *testing.T) {
+
+	values := map[string]string{"team": "infra", "env": "prod"}
+
+	tests := []struct {
+		name     string
+		matcher  Matcher
+		expected bool
+	}{
+		{name: "equal", matcher: Matcher{Key: "team", Operator: "=", Value: "infra"}, expected: true},
+		{name: "equal other value", matcher: Matcher{Key: "team", Operator: "=", Value: "web"}, expected: false},
+		{name: "equal missing key as empty", matcher: Matcher{Key: "zone", Operator: "=", Value: ""}, expected: true},
+		{name: "not equal", matcher: Matcher{Key: "team", Operator: "!=", Value: "web"}, expected: true},
+		{name: "not equal same value", matcher: Matcher{Key: "team", Operator: "!=", Value: "infra"}, expected: false},
+		{name: "not equal missing key", matcher: Matcher{Key: "zone", Operator: "!=", Value: "a"}, expected: true},
+		{name: "regexp", matcher: Matcher{Key: "team", Operator: "=~", Value: "inf.*"}, expected: true},
+		{name: "regexp alternation", matcher: Matcher{Key: "env", Operator: "=~", Value: "dev|prod"}, expected: true},
+		{name: "regexp anchored at start", matcher: Matcher{Key: "team", Operator: "=~", Value: "nfra"}, expected: false},
+		{name: "regexp anchored at end", matcher: Matcher{Key: "team", Operator: "=~", Value: "infr"}, expected: false},
+		{name: "regexp missing key as empty", matcher: Matcher{Key: "zone", Operator: "=~", Value: ".*"}, expected: true},
+		{name: "regexp missing key", matcher: Matcher{Key: "zone", Operator: "=~", Value: ".+"}, expected: false},
+		{name: "not regexp", matcher: Matcher{Key: "team", Operator: "!~", Value: "web.*"}, expected: true},
+		{name: "not regexp anchored", matcher: Matcher{Key: "team", Operator: "!~", Value: "inf"}, expected: true},
+		{name: "not regexp matching", matcher: Matcher{Key: "team", Operator: "!~", Value: "infra"}, expected: false},
+		{name: "not regexp missing key", matcher: Matcher{Key: "zone", Operator: "!~", Value: ".+"}, expected: true},
+		{name: "exists", matcher: Matcher{Key: "team", Operator: "exists"}, expected: true},
+		{name: "exists missing key", matcher: Matcher{Key: "zone", Operator: "exists"}, expected: false},
+		{name: "not exists", matcher: Matcher{Key: "zone", Operator: "notexists"}, expected: true},
+		{name: "not exists present key", matcher: Matcher{Key: "team", Operator: "notexists"}, expected: false},
+		{name: "in", matcher: Matcher{Key: "env", Operator: "in", Values: []string{"dev", "prod"}}, expected: true},
+		{name: "in other value", matcher: Matcher{Key: "env", Operator: "in", Values: []string{"dev", "staging"}}, expected: false},
+		{name: "in missing key", matcher: Matcher{Key: "zone", Operator: "in", Values: []string{""}}, expected: false},
+		{name: "not in", matcher: Matcher{Key: "env", Operator: "notin", Values: []string{"dev", "staging"}}, expected: true},
+		{name: "not in listed value", matcher: Matcher{Key: "env", Operator: "notin", Values: []string{"dev", "prod"}}, expected: false},
+		{name: "not in missing key", matcher: Matcher{Key: "zone", Operator: "notin", Values: []string{""}}, expected: true},
+		{name: "unknown operator", matcher: Matcher{Key: "team", Operator: "~", Value: "infra"}, expected: false},
+		{name: "invalid regexp", matcher: Matcher{Key: "team", Operator: "=~", Value: "("}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := test.matcher.Matches(values); matches != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, matches)
+			}
+		})
+	}
+}
+
+func TestMatcherCompile(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		matcher  Matcher
+		operator string
+		valid    bool
+	}{
+		{name: "equal", matcher: Matcher{Key: "team", Operator: "=", Value: "infra"}, operator: "=", valid: true},
+		{name: "regexp", matcher: Matcher{Key: "team", Operator: "=~", Value: "inf.*"}, operator: "=~", valid: true},
+		{name: "not exists with a space", matcher: Matcher{Key: "team", Operator: "not exists"}, operator: "notexists", valid: true},
+		{name: "not in upper case", matcher: Matcher{Key: "team", Operator: "NOT IN", Values: []string{"a"}}, operator: "notin", valid: true},
+		{name: "missing key", matcher: Matcher{Operator: "="}, valid: false},
+		{name: "unknown operator", matcher: Matcher{Key: "team", Operator: "=="}, valid: false},
+		{name: "invalid regexp", matcher: Matcher{Key: "team", Operator: "!~", Value: "["}, valid: false},
+		{name: "in without values", matcher: Matcher{Key: "team", Operator: "in"}, valid: false},
+		{name: "not in without values", matcher: Matcher{Key: "team", Operator: "notin"}, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.matcher.Compile()
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if test.matcher.Operator != test.operator {
+				t.Errorf("expected operator %q, got %q", test.operator, test.matcher.Operator)
+			}
+		})
+	}
+}
+
+func TestIsMatchingMatchers(t *testing.T) {
+
+	values := map[string]string{"team": "infra", "env": "prod"}
+
+	tests := []struct {
+		name     string
+		matchers []Matcher
+		expected bool
+	}{
+		{name: "no matchers", expected: true},
+		{name: "all matching", matchers: []Matcher{
+			{Key: "team", Operator: "=", Value: "infra"},
+			{Key: "env", Operator: "=~", Value: "prod|staging"},
+		}, expected: true},
+		{name: "one not matching", matchers: []Matcher{
+			{Key: "team", Operator: "=", Value: "infra"},
+			{Key: "env", Operator: "notin", Values: []string{"prod"}},
+		}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := IsMatchingMatchers(test.matchers, values); matches != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, matches)
+			}
+		})
+	}
+}
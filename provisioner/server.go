@@ -0,0 +1,192 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// State of the provisioning loop shared with the HTTP handlers
+type Status struct {
+	sync.Mutex
+	Ready       bool
+	LastSuccess time.Time
+	// A reconcile was requested over HTTP and did not start yet
+	TriggerPending bool
+	TriggeredAt    time.Time
+	// Result of the last cycle requested over HTTP
+	LastTriggeredRun *RunResult
+	LastDrift        *DriftReport
+}
+
+type RunResult struct {
+	TriggeredAt time.Time `json:"triggeredAt"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	Success     bool      `json:"success"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func NewRunResult(triggeredAt time.Time, startedAt time.Time, err error) *RunResult {
+	result := &RunResult{
+		TriggeredAt: triggeredAt,
+		StartedAt:   startedAt,
+		FinishedAt:  time.Now(),
+		Success:     err == nil,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (s *Status) SetDrift(report *DriftReport) {
+	s.Lock()
+	defer s.Unlock()
+	s.LastDrift = report
+}
+
+func (s *Status) GetDrift() *DriftReport {
+	s.Lock()
+	defer s.Unlock()
+	return s.LastDrift
+}
+
+// Answer of the reconcile endpoint
+type TriggerStatus struct {
+	Pending     bool       `json:"pending"`
+	TriggeredAt *time.Time `json:"triggeredAt,omitempty"`
+	LastRun     *RunResult `json:"lastRun"`
+}
+
+// Mark the provisioner ready after a successful cycle
+func (s *Status) Succeeded() {
+	s.Lock()
+	defer s.Unlock()
+	s.Ready = true
+	s.LastSuccess = time.Now()
+}
+
+func (s *Status) IsReady() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.Ready
+}
+
+// Record a reconcile request, requests made before the cycle starts are merged
+func (s *Status) Trigger() {
+	s.Lock()
+	defer s.Unlock()
+	if !s.TriggerPending {
+		s.TriggerPending = true
+		s.TriggeredAt = time.Now()
+	}
+}
+
+// Take the pending reconcile request, if any, when a cycle starts
+func (s *Status) TakeTrigger() (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.TriggerPending {
+		return time.Time{}, false
+	}
+	s.TriggerPending = false
+	return s.TriggeredAt, true
+}
+
+func (s *Status) SetTriggeredRun(result *RunResult) {
+	s.Lock()
+	defer s.Unlock()
+	s.LastTriggeredRun = result
+}
+
+func (s *Status) GetTriggerStatus() TriggerStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	status := TriggerStatus{
+		Pending: s.TriggerPending,
+		LastRun: s.LastTriggeredRun,
+	}
+	if s.TriggerPending {
+		triggeredAt := s.TriggeredAt
+		status.TriggeredAt = &triggeredAt
+	}
+	return status
+}
+
+// Handler serving the health, readiness, metrics, reconcile and drift endpoints
+func (p *Provisioner) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+
+	// The process is alive as long as it answers
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	// Ready once logged in to Zabbix and a first cycle completed
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !p.Status.IsReady() {
+			http.Error(w, "no provisioning cycle completed yet", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.Handle("/metrics", promhttp.Handler())
+
+	// POST wakes the provisioning loop up, GET returns the result of the last requested cycle
+	mux.HandleFunc("/-/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			p.Status.Trigger()
+			Notify(p.Changed)
+			status = http.StatusAccepted
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		err := json.NewEncoder(w).Encode(p.Status.GetTriggerStatus())
+		if err != nil {
+			log.Errorf("Error while writing the reconcile status: %s", err)
+		}
+	})
+
+	// Report of the last drift check
+	mux.HandleFunc("/-/drift", func(w http.ResponseWriter, r *http.Request) {
+		report := p.Status.GetDrift()
+		if report == nil {
+			http.Error(w, "no drift check completed yet", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(report)
+		if err != nil {
+			log.Errorf("Error while writing the drift report: %s", err)
+		}
+	})
+
+	return mux
+}
+
+// Serve the HTTP endpoints in the background, exit if the listen address can't be used
+func (p *Provisioner) StartServer() {
+
+	go func() {
+		log.Infof("Listening on %s", p.Config.ListenAddress)
+		err := http.ListenAndServe(p.Config.ListenAddress, p.Handler())
+		log.Fatalf("HTTP server stopped: %s", err)
+	}()
+}
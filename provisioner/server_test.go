@@ -0,0 +1,135 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getEndpoint(t *testing.T, handler http.Handler, path string, expectedCode int) string {
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if recorder.Code != expectedCode {
+		t.Fatalf("GET %s: expected status %d, got %d", path, expectedCode, recorder.Code)
+	}
+	return recorder.Body.String()
+}
+
+func TestHealthEndpoints(t *testing.T) {
+
+	p := &Provisioner{Changed: make(chan struct{}, 1), Status: &Status{}}
+	handler := p.Handler()
+
+	// Alive from the start, ready once a cycle succeeded
+	getEndpoint(t, handler, "/healthz", http.StatusOK)
+	getEndpoint(t, handler, "/readyz", http.StatusServiceUnavailable)
+
+	p.Status.Succeeded()
+	getEndpoint(t, handler, "/healthz", http.StatusOK)
+	getEndpoint(t, handler, "/readyz", http.StatusOK)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+
+	p := &Provisioner{Changed: make(chan struct{}, 1), Status: &Status{}}
+
+	body := getEndpoint(t, p.Handler(), "/metrics", http.StatusOK)
+	for _, name := range []string{
+		"zabbix_provisioner_reconcile_duration_seconds",
+		"zabbix_provisioner_deletion_guard_triggered_total",
+		"zabbix_provisioner_planned_deletions",
+	} {
+		if !strings.Contains(body, name) {
+			t.Errorf("expected the metric %s to be exposed", name)
+		}
+	}
+}
+
+func getTriggerStatus(t *testing.T, handler http.Handler, method string, expectedCode int) TriggerStatus {
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, "/-/reconcile", nil))
+
+	if recorder.Code != expectedCode {
+		t.Fatalf("%s /-/reconcile: expected status %d, got %d", method, expectedCode, recorder.Code)
+	}
+
+	var status TriggerStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Fatalf("%s /-/reconcile: can't decode the answer: %s", method, err)
+	}
+	return status
+}
+
+func TestReconcileEndpoint(t *testing.T) {
+
+	p := &Provisioner{Changed: make(chan struct{}, 1), Status: &Status{}}
+	handler := p.Handler()
+
+	status := getTriggerStatus(t, handler, http.MethodGet, http.StatusOK)
+	if status.Pending || status.LastRun != nil {
+		t.Fatalf("expected nothing requested yet, got %+v", status)
+	}
+
+	// Requests made before the cycle starts are merged
+	first := getTriggerStatus(t, handler, http.MethodPost, http.StatusAccepted)
+	second := getTriggerStatus(t, handler, http.MethodPost, http.StatusAccepted)
+	if !first.Pending || first.TriggeredAt == nil || !second.TriggeredAt.Equal(*first.TriggeredAt) {
+		t.Fatalf("expected a single pending request, got %+v then %+v", first, second)
+	}
+
+	select {
+	case <-p.Changed:
+	default:
+		t.Fatal("expected the provisioning loop to be woken up")
+	}
+
+	// The loop takes the request and records the result of its cycle
+	triggeredAt, triggered := p.Status.TakeTrigger()
+	if !triggered || !triggeredAt.Equal(*first.TriggeredAt) {
+		t.Fatalf("expected the pending request to be taken")
+	}
+	p.Status.SetTriggeredRun(NewRunResult(triggeredAt, time.Now(), errors.New("reading rules: timeout")))
+
+	status = getTriggerStatus(t, handler, http.MethodGet, http.StatusOK)
+	if status.Pending || status.LastRun == nil || status.LastRun.Success || status.LastRun.Error != "reading rules: timeout" {
+		t.Errorf("expected the failed run, got %+v", status)
+	}
+}
+
+func TestReconcileEndpointMethod(t *testing.T) {
+
+	p := &Provisioner{Changed: make(chan struct{}, 1), Status: &Status{}}
+
+	recorder := httptest.NewRecorder()
+	p.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/-/reconcile", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("expected a 405 allowing GET and POST, got %d and %q", recorder.Code, recorder.Header().Get("Allow"))
+	}
+	if p.Status.TriggerPending {
+		t.Error("expected no reconcile to be requested")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+
+	p := &Provisioner{Changed: make(chan struct{}, 1)}
+
+	// A change notified during the debounce is part of the cycle about to start
+	Notify(p.Changed)
+	Notify(p.Changed)
+	p.Debounce()
+
+	select {
+	case <-p.Changed:
+		t.Error("expected the changes received during the debounce to be merged")
+	default:
+	}
+}
@@ -0,0 +1,109 @@
+package provisioner
+
+import (
+	"fmt"
+	"github.com/gmauleon/zabbix-client"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Mapping from the values of a rule label or annotation to Zabbix trigger priorities
+// Values and Default are priority names: not classified, information, warning, average, high, disaster (or critical)
+type SeverityMappingConfig struct {
+	Label      string            `yaml:"label"`
+	Annotation string            `yaml:"annotation"`
+	Values     map[string]string `yaml:"values"`
+	Default    string            `yaml:"default"`
+}
+
+// Check that all the priority names of a mapping are known
+func (c *SeverityMappingConfig) Validate() error {
+
+	if len(c.Label) != 0 && len(c.Annotation) != 0 {
+		return fmt.Errorf("severity mapping: only one of label or annotation can be set")
+	}
+
+	if _, ok := ParseZabbixPriority(c.Default); len(c.Default) != 0 && !ok {
+		return fmt.Errorf("severity mapping: unknown default priority %q", c.Default)
+	}
+
+	for value, priority := range c.Values {
+		if _, ok := ParseZabbixPriority(priority); !ok {
+			return fmt.Errorf("severity mapping: unknown priority %q for %q", priority, value)
+		}
+	}
+
+	return nil
+}
+
+// Get the mapped value of a rule, from its label or annotation
+func (c *SeverityMappingConfig) ValueOf(rule PrometheusRule) (string, bool) {
+
+	if len(c.Label) != 0 {
+		value, ok := rule.Labels[c.Label]
+		return value, ok
+	}
+
+	if len(c.Annotation) != 0 {
+		value, ok := rule.Annotations[c.Annotation]
+		return value, ok
+	}
+
+	return "", false
+}
+
+// Get the priority of the trigger of a rule: zabbix_trigger_severity annotation, severity mapping of the host
+// or the global one, then the default of the mapping
+func (p *Provisioner) GetTriggerPriority(hostConfig HostConfig, rule PrometheusRule) zabbix.PriorityType {
+
+	if severity, ok := rule.Annotations["zabbix_trigger_severity"]; ok {
+		if priority, ok := ParseZabbixPriority(severity); ok {
+			return priority
+		}
+		if len(strings.TrimSpace(severity)) != 0 {
+			log.Warnf("Rule %s has an unknown zabbix_trigger_severity %q, using the severity mapping", rule.Name, severity)
+		}
+	}
+
+	mapping := p.Config.SeverityMapping
+	if hostConfig.SeverityMapping != nil {
+		mapping = *hostConfig.SeverityMapping
+	}
+
+	// Validated when loading the configuration
+	defaultPriority, _ := ParseZabbixPriority(mapping.Default)
+
+	value, ok := mapping.ValueOf(rule)
+	if !ok {
+		return defaultPriority
+	}
+
+	if name, ok := mapping.Values[value]; ok {
+		priority, _ := ParseZabbixPriority(name)
+		return priority
+	}
+
+	log.Warnf("Rule %s has an unknown severity %q, using %s", rule.Name, value, PriorityName[defaultPriority])
+	return defaultPriority
+}
+
+// Get a Zabbix priority from its name, an empty name is not a priority so that mappings and defaults apply
+func ParseZabbixPriority(name string) (zabbix.PriorityType, bool) {
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "not classified":
+		return zabbix.NotClassified, true
+	case "information":
+		return zabbix.Information, true
+	case "warning":
+		return zabbix.Warning, true
+	case "average":
+		return zabbix.Average, true
+	case "high":
+		return zabbix.High, true
+	case "disaster", "critical":
+		return zabbix.Critical, true
+	default:
+		return zabbix.NotClassified, false
+	}
+}
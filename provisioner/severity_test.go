@@ -0,0 +1,117 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"testing"
+)
+
+func TestParseZabbixPriority(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		priority string
+		expected zabbix.PriorityType
+		valid    bool
+	}{
+		{name: "empty", priority: "", expected: zabbix.NotClassified, valid: false},
+		{name: "not classified", priority: "not classified", expected: zabbix.NotClassified, valid: true},
+		{name: "information", priority: "information", expected: zabbix.Information, valid: true},
+		{name: "warning", priority: "warning", expected: zabbix.Warning, valid: true},
+		{name: "average", priority: "average", expected: zabbix.Average, valid: true},
+		{name: "high", priority: "high", expected: zabbix.High, valid: true},
+		{name: "disaster", priority: "disaster", expected: zabbix.Critical, valid: true},
+		{name: "critical", priority: "critical", expected: zabbix.Critical, valid: true},
+		{name: "case and spaces", priority: " High ", expected: zabbix.High, valid: true},
+		{name: "unknown", priority: "urgent", expected: zabbix.NotClassified, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			priority, ok := ParseZabbixPriority(test.priority)
+			if ok != test.valid {
+				t.Fatalf("expected valid %v, got %v", test.valid, ok)
+			}
+			if priority != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, priority)
+			}
+		})
+	}
+}
+
+func TestGetTriggerPriority(t *testing.T) {
+
+	globalMapping := SeverityMappingConfig{
+		Label:   "severity",
+		Values:  map[string]string{"critical": "disaster", "warning": "warning"},
+		Default: "average",
+	}
+
+	hostMapping := &SeverityMappingConfig{
+		Annotation: "priority",
+		Values:     map[string]string{"p1": "high"},
+		Default:    "information",
+	}
+
+	tests := []struct {
+		name        string
+		mapping     *SeverityMappingConfig
+		labels      map[string]string
+		annotations map[string]string
+		expected    zabbix.PriorityType
+	}{
+		{name: "mapped label", labels: map[string]string{"severity": "critical"}, expected: zabbix.Critical},
+		{name: "other mapped label", labels: map[string]string{"severity": "warning"}, expected: zabbix.Warning},
+		{name: "unknown value", labels: map[string]string{"severity": "page"}, expected: zabbix.Average},
+		{name: "missing label", expected: zabbix.Average},
+		{name: "annotation override", labels: map[string]string{"severity": "critical"},
+			annotations: map[string]string{"zabbix_trigger_severity": "information"}, expected: zabbix.Information},
+		{name: "unknown annotation override", labels: map[string]string{"severity": "critical"},
+			annotations: map[string]string{"zabbix_trigger_severity": "urgent"}, expected: zabbix.Critical},
+		{name: "empty annotation override", labels: map[string]string{"severity": "critical"},
+			annotations: map[string]string{"zabbix_trigger_severity": ""}, expected: zabbix.Critical},
+		{name: "host mapping", mapping: hostMapping, labels: map[string]string{"severity": "critical"},
+			annotations: map[string]string{"priority": "p1"}, expected: zabbix.High},
+		{name: "host mapping default", mapping: hostMapping, labels: map[string]string{"severity": "critical"}, expected: zabbix.Information},
+		{name: "empty host mapping", mapping: &SeverityMappingConfig{}, labels: map[string]string{"severity": "critical"}, expected: zabbix.NotClassified},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provisioner{Config: ProvisionerConfig{SeverityMapping: globalMapping}}
+			rule := PrometheusRule{Name: "NodeDown", Labels: test.labels, Annotations: test.annotations}
+
+			priority := p.GetTriggerPriority(HostConfig{Name: "host", SeverityMapping: test.mapping}, rule)
+			if priority != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, priority)
+			}
+		})
+	}
+}
+
+func TestSeverityMappingValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		mapping SeverityMappingConfig
+		valid   bool
+	}{
+		{name: "empty", valid: true},
+		{name: "label", mapping: SeverityMappingConfig{Label: "severity", Values: map[string]string{"critical": "high"}, Default: "warning"}, valid: true},
+		{name: "label and annotation", mapping: SeverityMappingConfig{Label: "severity", Annotation: "severity"}, valid: false},
+		{name: "unknown default", mapping: SeverityMappingConfig{Label: "severity", Default: "urgent"}, valid: false},
+		{name: "unknown value", mapping: SeverityMappingConfig{Label: "severity", Values: map[string]string{"critical": "urgent"}}, valid: false},
+		{name: "empty value", mapping: SeverityMappingConfig{Label: "severity", Values: map[string]string{"critical": ""}}, valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.mapping.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
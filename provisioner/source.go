@@ -0,0 +1,330 @@
+package provisioner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RuleSourcePrometheus     = "prometheus"
+	RuleSourcePrometheusHTML = "prometheus-html"
+	RuleSourceFile           = "file"
+	RuleSourceKubernetes     = "kubernetes"
+	RuleSourceCortex         = "cortex"
+	RuleSourceMimir          = "mimir"
+	RuleSourceThanos         = "thanos"
+)
+
+// A RuleSource is anything able to give us a list of alerting rules
+type RuleSource interface {
+	Name() string
+	GetRules() ([]PrometheusRule, error)
+}
+
+// A WatchableRuleSource can tell as soon as its rules changed, without waiting for the next polling
+type WatchableRuleSource interface {
+	RuleSource
+	Watch(changed chan<- struct{}, stop <-chan struct{})
+}
+
+type RuleSourceConfig struct {
+	Name            string           `yaml:"name"`
+	Type            string           `yaml:"type"`
+	Url             string           `yaml:"url"`
+	BasicAuth       *BasicAuthConfig `yaml:"basicAuth"`
+	BearerToken     string           `yaml:"bearerToken"`
+	BearerTokenFile string           `yaml:"bearerTokenFile"`
+	TLS             TLSConfig        `yaml:"tls"`
+	Paths           []string         `yaml:"paths"`
+	WatchInterval   int              `yaml:"watchInterval"`
+	Kubeconfig      string           `yaml:"kubeconfig"`
+	Namespace       string           `yaml:"namespace"`
+	LabelSelector   string           `yaml:"labelSelector"`
+	Tenants         []string         `yaml:"tenants"`
+	TenantHeader    string           `yaml:"tenantHeader"`
+}
+
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// Rules read from a Prometheus server, via the API endpoint or the legacy HTML page
+type PrometheusRuleSource struct {
+	SourceName string
+	Url        string
+	HTML       bool
+	Client     *http.Client
+}
+
+// An alert name declared by more than one source
+type RuleConflict struct {
+	Name    string
+	Sources []string
+}
+
+func (s *PrometheusRuleSource) Name() string {
+	return s.SourceName
+}
+
+func (s *PrometheusRuleSource) GetRules() ([]PrometheusRule, error) {
+	if s.HTML {
+		return GetRulesFromURL(s.Client, s.Url)
+	}
+	return GetRulesFromAPI(s.Client, s.Url)
+}
+
+// Create a rule source from its configuration
+func NewRuleSource(cfg RuleSourceConfig) (RuleSource, error) {
+
+	switch cfg.Type {
+	case RuleSourcePrometheus, RuleSourcePrometheusHTML:
+		client, err := NewHTTPClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule source %s: %s", cfg.Name, err)
+		}
+
+		return &PrometheusRuleSource{
+			SourceName: cfg.Name,
+			Url:        cfg.Url,
+			HTML:       cfg.Type == RuleSourcePrometheusHTML,
+			Client:     client,
+		}, nil
+	case RuleSourceFile:
+		if len(cfg.Paths) == 0 {
+			return nil, fmt.Errorf("rule source %s: no paths declared", cfg.Name)
+		}
+
+		watchInterval := cfg.WatchInterval
+		if watchInterval <= 0 {
+			watchInterval = 10
+		}
+
+		return &FileRuleSource{
+			SourceName:    cfg.Name,
+			Paths:         cfg.Paths,
+			WatchInterval: time.Duration(watchInterval) * time.Second,
+		}, nil
+	case RuleSourceKubernetes:
+		client, err := NewKubernetesClient(cfg.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("rule source %s: %s", cfg.Name, err)
+		}
+
+		return NewKubernetesRuleSource(cfg.Name, client, cfg.Namespace, cfg.LabelSelector), nil
+	case RuleSourceCortex, RuleSourceMimir, RuleSourceThanos:
+		client, err := NewHTTPClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule source %s: %s", cfg.Name, err)
+		}
+
+		tenantHeader := cfg.TenantHeader
+		if len(tenantHeader) == 0 {
+			tenantHeader = DefaultTenantHeader
+		}
+
+		return &RulerRuleSource{
+			SourceName:   cfg.Name,
+			Url:          cfg.Url,
+			Thanos:       cfg.Type == RuleSourceThanos,
+			Tenants:      cfg.Tenants,
+			TenantHeader: tenantHeader,
+			Client:       client,
+		}, nil
+	default:
+		return nil, fmt.Errorf("rule source %s: unknown type '%s'", cfg.Name, cfg.Type)
+	}
+}
+
+// Return the configured rule sources, or a single one built from rulesUrl/rulesType if none are declared
+func (cfg *ProvisionerConfig) GetRuleSourceConfigs() []RuleSourceConfig {
+
+	if len(cfg.RuleSources) != 0 {
+		return cfg.RuleSources
+	}
+
+	sourceType := RuleSourcePrometheus
+	if GetRulesType(cfg.RulesType, cfg.RulesUrl) == RulesTypeHTML {
+		sourceType = RuleSourcePrometheusHTML
+	}
+
+	return []RuleSourceConfig{
+		{
+			Name: "default",
+			Type: sourceType,
+			Url:  cfg.RulesUrl,
+		},
+	}
+}
+
+// Create an http client with the authentication and TLS settings of a rule source
+func NewHTTPClient(cfg RuleSourceConfig) (*http.Client, error) {
+
+	tlsConfig, err := NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	bearerToken := cfg.BearerToken
+	if len(cfg.BearerTokenFile) != 0 {
+		token, err := ioutil.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read bearer token file: %s", err)
+		}
+		bearerToken = strings.TrimSpace(string(token))
+	}
+
+	return &http.Client{
+		Transport: &authRoundTripper{
+			basicAuth:   cfg.BasicAuth,
+			bearerToken: bearerToken,
+			next:        &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// Create a TLS configuration from the provided CA and client certificate files
+func NewTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if len(cfg.CAFile) != 0 {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA file: %s", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if len(cfg.CertFile) != 0 || len(cfg.KeyFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Add the authentication headers of a rule source, and any other fixed header, to every request
+type authRoundTripper struct {
+	basicAuth   *BasicAuthConfig
+	bearerToken string
+	headers     map[string]string
+	next        http.RoundTripper
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	// A RoundTripper must not modify the original request
+	req = req.Clone(req.Context())
+
+	if rt.basicAuth != nil {
+		req.SetBasicAuth(rt.basicAuth.Username, rt.basicAuth.Password)
+	} else if len(rt.bearerToken) != 0 {
+		req.Header.Set("Authorization", "Bearer "+rt.bearerToken)
+	}
+
+	for name, value := range rt.headers {
+		req.Header.Set(name, value)
+	}
+
+	next := rt.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return next.RoundTrip(req)
+}
+
+// Signal a change without blocking if one is already pending
+func Notify(changed chan<- struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
+
+// Get the rules from all the sources, tagging each rule with the name of its source
+func GetRulesFromSources(sources []RuleSource) ([]PrometheusRule, error) {
+
+	rules := []PrometheusRule{}
+
+	for _, source := range sources {
+		sourceRules, err := source.GetRules()
+		if err != nil {
+			RuleSourceErrors.WithLabelValues(source.Name()).Inc()
+			return nil, fmt.Errorf("rule source %s: %s", source.Name(), err)
+		}
+
+		for i := range sourceRules {
+			sourceRules[i].Source = source.Name()
+		}
+
+		log.Infof("Got %d rules from source %s", len(sourceRules), source.Name())
+		RulesFetched.WithLabelValues(source.Name()).Set(float64(len(sourceRules)))
+		rules = append(rules, sourceRules...)
+	}
+
+	for _, conflict := range FindRuleConflicts(rules) {
+		log.Warnf("Rule %s is declared by multiple sources: %s", conflict.Name, strings.Join(conflict.Sources, ", "))
+	}
+
+	return rules, nil
+}
+
+// Find alert names coming from more than one source
+func FindRuleConflicts(rules []PrometheusRule) []RuleConflict {
+
+	sourcesByName := map[string]map[string]struct{}{}
+	for _, rule := range rules {
+		if _, ok := sourcesByName[rule.Name]; !ok {
+			sourcesByName[rule.Name] = map[string]struct{}{}
+		}
+		sourcesByName[rule.Name][rule.Source] = struct{}{}
+	}
+
+	conflicts := []RuleConflict{}
+	for name, sources := range sourcesByName {
+		if len(sources) < 2 {
+			continue
+		}
+
+		conflict := RuleConflict{Name: name}
+		for source := range sources {
+			conflict.Sources = append(conflict.Sources, source)
+		}
+		sort.Strings(conflict.Sources)
+		conflicts = append(conflicts, conflict)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Name < conflicts[j].Name
+	})
+
+	return conflicts
+}
@@ -0,0 +1,138 @@
+package provisioner
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Rule source with a name, returning a fixed list of rules or an error
+type namedRuleSource struct {
+	name  string
+	rules []PrometheusRule
+	err   error
+}
+
+func (s *namedRuleSource) Name() string {
+	return s.name
+}
+
+func (s *namedRuleSource) GetRules() ([]PrometheusRule, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	rules := make([]PrometheusRule, len(s.rules))
+	copy(rules, s.rules)
+	return rules, nil
+}
+
+func newConflictingSources() []RuleSource {
+	return []RuleSource{
+		&namedRuleSource{name: "prod", rules: []PrometheusRule{
+			{Name: "NodeDown", Annotations: map[string]string{"description": "prod"}},
+			{Name: "DiskFull", Annotations: map[string]string{"description": "prod"}},
+		}},
+		&namedRuleSource{name: "staging", rules: []PrometheusRule{
+			{Name: "NodeDown", Annotations: map[string]string{"description": "staging"}},
+		}},
+	}
+}
+
+func TestGetRulesFromSources(t *testing.T) {
+
+	rules, err := GetRulesFromSources(newConflictingSources())
+	if err != nil {
+		t.Fatalf("GetRulesFromSources: %s", err)
+	}
+
+	sources := []string{}
+	for _, rule := range rules {
+		sources = append(sources, rule.Name+"/"+rule.Source)
+	}
+	expected := []string{"NodeDown/prod", "DiskFull/prod", "NodeDown/staging"}
+	if !reflect.DeepEqual(sources, expected) {
+		t.Errorf("expected rules %v, got %v", expected, sources)
+	}
+
+	conflicts := FindRuleConflicts(rules)
+	expectedConflicts := []RuleConflict{{Name: "NodeDown", Sources: []string{"prod", "staging"}}}
+	if !reflect.DeepEqual(conflicts, expectedConflicts) {
+		t.Errorf("expected conflicts %+v, got %+v", expectedConflicts, conflicts)
+	}
+}
+
+func TestGetRulesFromSourcesError(t *testing.T) {
+
+	// Rules of the other sources alone would delete the objects of the failing one
+	sources := append(newConflictingSources(), &namedRuleSource{name: "broken", err: errors.New("connection refused")})
+
+	rules, err := GetRulesFromSources(sources)
+	if err == nil {
+		t.Fatalf("expected an error, got %d rules", len(rules))
+	}
+	if err.Error() != "rule source broken: connection refused" {
+		t.Errorf("expected the failing source in the error, got %s", err)
+	}
+}
+
+func TestFillFromPrometheusSources(t *testing.T) {
+
+	p := &Provisioner{
+		Config: ProvisionerConfig{
+			ZabbixKeyPrefix: "prometheus",
+			ZabbixHosts: []HostConfig{
+				{Name: "all", MatchAll: true},
+				{Name: "staging", SourceSelector: []string{"staging"}},
+			},
+		},
+		RuleSources:  newConflictingSources(),
+		CustomZabbix: NewCustomZabbix(),
+	}
+
+	err := p.FillFromPrometheus()
+	if err != nil {
+		t.Fatalf("FillFromPrometheus: %s", err)
+	}
+
+	// The first source declaring a rule wins on a host selecting both
+	expected := map[string]map[string]string{
+		"all":     {"prometheus.nodedown": "prod", "prometheus.diskfull": "prod"},
+		"staging": {"prometheus.nodedown": "staging"},
+	}
+	for hostName, descriptions := range expected {
+		items := p.Hosts[hostName].Items
+		if len(items) != len(descriptions) {
+			t.Errorf("expected %d items on %s, got %d", len(descriptions), hostName, len(items))
+			continue
+		}
+		for key, description := range descriptions {
+			if item, ok := items[key]; !ok || withoutDesired(item.Description) != description {
+				t.Errorf("expected item %s from %s on %s, got %+v", key, description, hostName, item)
+			}
+		}
+	}
+}
+
+func TestGetRuleSourceConfigs(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		config   ProvisionerConfig
+		expected []RuleSourceConfig
+	}{
+		{name: "rulesUrl API", config: ProvisionerConfig{RulesUrl: "http://prometheus/api/v1/rules"},
+			expected: []RuleSourceConfig{{Name: "default", Type: RuleSourcePrometheus, Url: "http://prometheus/api/v1/rules"}}},
+		{name: "rulesUrl HTML", config: ProvisionerConfig{RulesUrl: "http://prometheus/rules"},
+			expected: []RuleSourceConfig{{Name: "default", Type: RuleSourcePrometheusHTML, Url: "http://prometheus/rules"}}},
+		{name: "rule sources", config: ProvisionerConfig{RulesUrl: "http://prometheus/rules", RuleSources: []RuleSourceConfig{{Name: "files", Type: RuleSourceFile}}},
+			expected: []RuleSourceConfig{{Name: "files", Type: RuleSourceFile}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if configs := test.config.GetRuleSourceConfigs(); !reflect.DeepEqual(configs, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, configs)
+			}
+		})
+	}
+}
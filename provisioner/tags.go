@@ -0,0 +1,225 @@
+package provisioner
+
+import (
+	"fmt"
+	"github.com/gmauleon/zabbix-client"
+	"sort"
+	"strings"
+)
+
+// Applications became item tags named Application when upgrading to Zabbix 5.4, we use the same
+const ApplicationTag = "Application"
+
+// Item or trigger tag, trigger tags Zabbix 3.2+, item tags 5.4+
+type Tag struct {
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// Rule labels copied to the item and trigger tags, trigger tags Zabbix 3.2+, item tags 5.4+
+// All labels are copied when Include is empty, Rename maps label names to tag names
+type LabelTagsConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	Include []string          `yaml:"include"`
+	Exclude []string          `yaml:"exclude"`
+	Rename  map[string]string `yaml:"rename"`
+}
+
+// Get the tags of the labels of a rule
+func (c *LabelTagsConfig) Tags(labels map[string]string) []Tag {
+
+	tags := []Tag{}
+	if !c.Enabled {
+		return tags
+	}
+
+	for _, name := range sortedKeys(c.Selected(labels)) {
+		tagName := name
+		if renamed, ok := c.Rename[name]; ok {
+			tagName = renamed
+		}
+		tags = append(tags, Tag{Tag: tagName, Value: labels[name]})
+	}
+	return tags
+}
+
+// Names of the labels to copy
+func (c *LabelTagsConfig) Selected(labels map[string]string) map[string]struct{} {
+
+	selected := map[string]struct{}{}
+	if len(c.Include) == 0 {
+		for name := range labels {
+			selected[name] = struct{}{}
+		}
+	} else {
+		for _, name := range c.Include {
+			if _, ok := labels[name]; ok {
+				selected[name] = struct{}{}
+			}
+		}
+	}
+
+	for _, name := range c.Exclude {
+		delete(selected, name)
+	}
+	return selected
+}
+
+// Item or trigger id with its tags, read apart as the client has no support for them
+type ItemTags struct {
+	ItemId string `json:"itemid"`
+	Tags   []Tag  `json:"tags"`
+}
+
+type TriggerTags struct {
+	TriggerId string `json:"triggerid"`
+	Tags      []Tag  `json:"tags"`
+}
+
+// Item or trigger with its tags, sent in place of the client objects which have no support for them
+// Tags are always sent, an empty list removes the tags of an updated object
+type TaggedItem struct {
+	zabbix.Item
+	Tags []Tag `json:"tags"`
+}
+
+type TaggedTrigger struct {
+	zabbix.Trigger
+	Tags []Tag `json:"tags"`
+}
+
+// Parse a list of tags separated by comma, "name:value" or "name" for an empty value
+func ParseTags(value string) []Tag {
+
+	tags := []Tag{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		tag := Tag{Tag: part}
+		if i := strings.Index(part, ":"); i >= 0 {
+			tag = Tag{Tag: strings.TrimSpace(part[:i]), Value: strings.TrimSpace(part[i+1:])}
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func NewTagSet(tags []Tag) map[Tag]struct{} {
+	set := make(map[Tag]struct{}, len(tags))
+	for _, tag := range tags {
+		set[tag] = struct{}{}
+	}
+	return set
+}
+
+func EqualTags(i map[Tag]struct{}, j map[Tag]struct{}) bool {
+
+	if len(i) != len(j) {
+		return false
+	}
+
+	for tag := range i {
+		if _, ok := j[tag]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Sorted list of a tag set, to send or display
+func TagList(set map[Tag]struct{}) []Tag {
+
+	tags := make([]Tag, 0, len(set))
+	for tag := range set {
+		tags = append(tags, tag)
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Tag != tags[j].Tag {
+			return tags[i].Tag < tags[j].Tag
+		}
+		return tags[i].Value < tags[j].Value
+	})
+	return tags
+}
+
+func joinTags(set map[Tag]struct{}) string {
+	parts := []string{}
+	for _, tag := range TagList(set) {
+		parts = append(parts, fmt.Sprintf("%s:%s", tag.Tag, tag.Value))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Tags of the items of a host, by item id
+func (p *Provisioner) ItemsTagsGet(hostId string) (map[string][]Tag, error) {
+
+	items := []ItemTags{}
+	err := p.CallRaw("item.get", zabbix.Params{
+		"output":     []string{"itemid"},
+		"hostids":    hostId,
+		"inherited":  false,
+		"selectTags": "extend",
+	}, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]Tag, len(items))
+	for _, item := range items {
+		tags[item.ItemId] = item.Tags
+	}
+	return tags, nil
+}
+
+// Tags of the triggers of a host, by trigger id
+func (p *Provisioner) TriggersTagsGet(hostId string) (map[string][]Tag, error) {
+
+	triggers := []TriggerTags{}
+	err := p.CallRaw("trigger.get", zabbix.Params{
+		"output":     []string{"triggerid"},
+		"hostids":    hostId,
+		"inherited":  false,
+		"selectTags": "extend",
+	}, &triggers)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]Tag, len(triggers))
+	for _, trigger := range triggers {
+		tags[trigger.TriggerId] = trigger.Tags
+	}
+	return tags, nil
+}
+
+// Items with their tags, sent in the create and update calls when the version supports them
+func (p *Provisioner) TaggedItems(host *CustomHost, items zabbix.Items) interface{} {
+
+	if !p.UseTags() {
+		return items
+	}
+
+	taggedItems := make([]TaggedItem, 0, len(items))
+	for _, item := range items {
+		taggedItems = append(taggedItems, TaggedItem{Item: item, Tags: TagList(host.Items[item.Key].Tags)})
+	}
+	return taggedItems
+}
+
+// Triggers with their tags, sent in the create and update calls when the version supports them
+func (p *Provisioner) TaggedTriggers(host *CustomHost, triggers zabbix.Triggers) interface{} {
+
+	if !p.UseTriggerTags() {
+		return triggers
+	}
+
+	taggedTriggers := make([]TaggedTrigger, 0, len(triggers))
+	for _, trigger := range triggers {
+		taggedTriggers = append(taggedTriggers, TaggedTrigger{Trigger: trigger, Tags: TagList(host.Triggers[NormalizeExpression(trigger.Expression)].Tags)})
+	}
+	return taggedTriggers
+}
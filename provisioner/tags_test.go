@@ -0,0 +1,158 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		value    string
+		expected []Tag
+	}{
+		{name: "empty", value: "", expected: []Tag{}},
+		{name: "name and value", value: "team:infra", expected: []Tag{{Tag: "team", Value: "infra"}}},
+		{name: "name only", value: "paging", expected: []Tag{{Tag: "paging"}}},
+		{name: "empty value", value: "paging:", expected: []Tag{{Tag: "paging"}}},
+		{name: "several tags", value: "team:infra,paging,env:prod",
+			expected: []Tag{{Tag: "team", Value: "infra"}, {Tag: "paging"}, {Tag: "env", Value: "prod"}}},
+		{name: "spaces", value: " team : infra , env:prod ", expected: []Tag{{Tag: "team", Value: "infra"}, {Tag: "env", Value: "prod"}}},
+		{name: "empty parts", value: "team:infra,,", expected: []Tag{{Tag: "team", Value: "infra"}}},
+		{name: "colon in value", value: "url:http://example.com", expected: []Tag{{Tag: "url", Value: "http://example.com"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if tags := ParseTags(test.value); !reflect.DeepEqual(tags, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, tags)
+			}
+		})
+	}
+}
+
+func TestLabelTags(t *testing.T) {
+
+	labels := map[string]string{"severity": "critical", "team": "infra", "instance": "node1"}
+
+	tests := []struct {
+		name     string
+		config   LabelTagsConfig
+		expected []Tag
+	}{
+		{name: "disabled", config: LabelTagsConfig{Include: []string{"team"}}, expected: []Tag{}},
+		{name: "all labels", config: LabelTagsConfig{Enabled: true},
+			expected: []Tag{{Tag: "instance", Value: "node1"}, {Tag: "severity", Value: "critical"}, {Tag: "team", Value: "infra"}}},
+		{name: "include", config: LabelTagsConfig{Enabled: true, Include: []string{"team", "missing"}},
+			expected: []Tag{{Tag: "team", Value: "infra"}}},
+		{name: "exclude", config: LabelTagsConfig{Enabled: true, Exclude: []string{"instance"}},
+			expected: []Tag{{Tag: "severity", Value: "critical"}, {Tag: "team", Value: "infra"}}},
+		{name: "exclude wins over include", config: LabelTagsConfig{Enabled: true, Include: []string{"team", "severity"}, Exclude: []string{"severity"}},
+			expected: []Tag{{Tag: "team", Value: "infra"}}},
+		{name: "rename", config: LabelTagsConfig{Enabled: true, Include: []string{"team", "severity"}, Rename: map[string]string{"team": "owner"}},
+			expected: []Tag{{Tag: "severity", Value: "critical"}, {Tag: "owner", Value: "infra"}}},
+		{name: "rename of an excluded label", config: LabelTagsConfig{Enabled: true, Exclude: []string{"team", "instance"}, Rename: map[string]string{"team": "owner"}},
+			expected: []Tag{{Tag: "severity", Value: "critical"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if tags := test.config.Tags(labels); !reflect.DeepEqual(tags, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, tags)
+			}
+		})
+	}
+}
+
+func TestEqualTags(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		i        []Tag
+		j        []Tag
+		expected bool
+	}{
+		{name: "empty", expected: true},
+		{name: "same tags in another order", i: []Tag{{Tag: "a", Value: "1"}, {Tag: "b"}}, j: []Tag{{Tag: "b"}, {Tag: "a", Value: "1"}}, expected: true},
+		{name: "other value", i: []Tag{{Tag: "a", Value: "1"}}, j: []Tag{{Tag: "a", Value: "2"}}, expected: false},
+		{name: "missing tag", i: []Tag{{Tag: "a"}, {Tag: "b"}}, j: []Tag{{Tag: "a"}}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if equal := EqualTags(NewTagSet(test.i), NewTagSet(test.j)); equal != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, equal)
+			}
+		})
+	}
+}
+
+func TestItemsCreateTags(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		version  ZabbixVersion
+		expected interface{}
+	}{
+		{name: "item tags", version: ZabbixVersion{Major: 5, Minor: 4}, expected: []interface{}{map[string]interface{}{"tag": "team", "value": "infra"}}},
+		{name: "before item tags", version: ZabbixVersion{Major: 5, Minor: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			calls := []map[string]interface{}{}
+			server := newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+				"item.create": func(params map[string]interface{}) interface{} {
+					calls = append(calls, params)
+					return map[string][]string{"itemids": {"42"}}
+				},
+			})
+			defer server.Close()
+
+			p := &Provisioner{Api: zabbix.NewAPI(server.URL), ZabbixVersion: &test.version}
+			host := &CustomHost{Items: map[string]*CustomItem{}}
+			host.Items["prometheus.nodedown"] = &CustomItem{Tags: NewTagSet([]Tag{{Tag: "team", Value: "infra"}})}
+
+			// The tags are part of the create call, no update follows it
+			items := zabbix.Items{{Key: "prometheus.nodedown", Name: "NodeDown"}}
+			err := p.ItemsCreate(host, items)
+			if err != nil {
+				t.Fatalf("ItemsCreate: %s", err)
+			}
+			if len(calls) != 1 || !reflect.DeepEqual(calls[0]["tags"], test.expected) {
+				t.Errorf("expected a single call with tags %v, got %+v", test.expected, calls)
+			}
+			if items[0].ItemId != "42" {
+				t.Errorf("expected the id of the created item, got %q", items[0].ItemId)
+			}
+		})
+	}
+}
+
+func TestTriggersUpdateTags(t *testing.T) {
+
+	calls := []map[string]interface{}{}
+	server := newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+		"trigger.update": func(params map[string]interface{}) interface{} {
+			calls = append(calls, params)
+			return map[string][]string{"triggerids": {params["triggerid"].(string)}}
+		},
+	})
+	defer server.Close()
+
+	p := &Provisioner{Api: zabbix.NewAPI(server.URL), ZabbixVersion: &ZabbixVersion{Major: 3, Minor: 2}}
+	host := &CustomHost{Triggers: map[string]*CustomTrigger{}}
+	host.Triggers[NormalizeExpression("{host:prometheus.nodedown.last()}<>0")] = &CustomTrigger{Tags: map[Tag]struct{}{}}
+
+	// An empty list removes the tags set before
+	err := p.TriggersUpdate(host, zabbix.Triggers{{TriggerId: "7", Expression: "{host:prometheus.nodedown.last()}<>0"}})
+	if err != nil {
+		t.Fatalf("TriggersUpdate: %s", err)
+	}
+	if tags, ok := calls[0]["tags"]; len(calls) != 1 || !ok || !reflect.DeepEqual(tags, []interface{}{}) {
+		t.Errorf("expected a single call with no tags, got %+v", calls)
+	}
+}
@@ -0,0 +1,345 @@
+package provisioner
+
+import (
+	"fmt"
+	"github.com/gmauleon/zabbix-client"
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+// Description of the templates created by the provisioner, that's how we find the ones we own
+// even when no host uses them anymore
+const ManagedTemplateDescription = "Managed by alertmanager-zabbix-provisioner, do not edit"
+
+// Zabbix template, the client has no support for them
+type Template struct {
+	TemplateId  string              `json:"templateid,omitempty"`
+	Host        string              `json:"host,omitempty"`
+	Name        string              `json:"name,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Groups      zabbix.HostGroupIds `json:"groups,omitempty"`
+}
+
+type Templates []Template
+
+// Host with the templates linked to it
+type HostTemplates struct {
+	HostId          string    `json:"hostid"`
+	Host            string    `json:"host"`
+	ParentTemplates Templates `json:"parentTemplates"`
+}
+
+type HostsTemplates []HostTemplates
+
+// Names of the managed templates in the configuration
+func (p *Provisioner) GetTemplateNames() []string {
+
+	names := map[string]struct{}{}
+	for _, hostConfig := range p.HostConfigs {
+		if len(hostConfig.ZabbixTemplate) != 0 {
+			names[hostConfig.ZabbixTemplate] = struct{}{}
+		}
+	}
+	return sortedKeys(names)
+}
+
+// Get the managed template holding the items and triggers of hosts, creating it on first use
+func (p *Provisioner) GetTemplate(name string) *CustomHost {
+
+	if template, ok := p.Templates[name]; ok {
+		return template
+	}
+
+	templateGroup := &CustomHostGroup{
+		State: StateNew,
+		HostGroup: zabbix.HostGroup{
+			Name: p.Config.TemplateGroup,
+		},
+	}
+	if p.UseTemplateGroups() {
+		p.AddTemplateGroup(templateGroup)
+	} else {
+		p.AddHostGroup(templateGroup)
+	}
+
+	return p.AddTemplate(&CustomHost{
+		State: StateNew,
+		Host: zabbix.Host{
+			Host: name,
+			Name: name,
+		},
+		HostGroups:   map[string]struct{}{p.Config.TemplateGroup: {}},
+		Items:        map[string]*CustomItem{},
+		Applications: map[string]*CustomApplication{},
+		Triggers:     map[string]*CustomTrigger{},
+	})
+}
+
+// Load the managed templates and their items, applications and triggers from Zabbix,
+// then the templates linked to the hosts
+func (p *Provisioner) FillTemplatesFromZabbix() error {
+
+	err := p.FillConfiguredTemplatesFromZabbix()
+	if err != nil {
+		return err
+	}
+
+	// Templates currently linked to the hosts, even when no host uses managed templates anymore
+	hostIds := []string{}
+	for _, host := range p.Hosts {
+		if len(host.HostId) != 0 {
+			hostIds = append(hostIds, host.HostId)
+		}
+	}
+	if len(hostIds) == 0 {
+		return nil
+	}
+
+	hostsTemplates, err := p.HostsTemplatesGet(hostIds)
+	if err != nil {
+		return fmt.Errorf("getting templates of hosts: %s", err)
+	}
+
+	for _, hostTemplates := range hostsTemplates {
+		host, ok := p.Hosts[hostTemplates.Host]
+		if !ok {
+			continue
+		}
+		for _, template := range hostTemplates.ParentTemplates {
+			host.LinkedTemplates[template.Host] = template.TemplateId
+			if template.Description == ManagedTemplateDescription {
+				p.ManagedTemplates[template.Host] = struct{}{}
+			}
+		}
+	}
+
+	// Count what unlinking templates would clear, for the deletion guard
+	for _, host := range p.Hosts {
+		_, unlink := p.GetTemplateLinks(host)
+		for _, name := range unlink {
+			if _, ok := p.TemplateObjects[name]; ok {
+				continue
+			}
+
+			count, err := p.TemplateObjectsCount(host.LinkedTemplates[name])
+			if err != nil {
+				return fmt.Errorf("counting objects of template %s: %s", name, err)
+			}
+			p.TemplateObjects[name] = count
+		}
+	}
+
+	return nil
+}
+
+// Load the templates of the configuration and their items, applications and triggers
+func (p *Provisioner) FillConfiguredTemplatesFromZabbix() error {
+
+	templateNames := p.GetTemplateNames()
+	if len(templateNames) == 0 {
+		return nil
+	}
+
+	if p.UseTemplateGroups() {
+		zabbixTemplateGroups, err := p.TemplateGroupsGet(zabbix.Params{
+			"output": "extend",
+			"filter": map[string][]string{
+				"name": {p.Config.TemplateGroup},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("getting template groups: %s", err)
+		}
+
+		for _, zabbixTemplateGroup := range zabbixTemplateGroups {
+			p.AddTemplateGroup(&CustomHostGroup{
+				State:     StateOld,
+				HostGroup: zabbixTemplateGroup,
+			})
+		}
+	}
+
+	zabbixTemplates, err := p.TemplatesGet(zabbix.Params{
+		"output": []string{"templateid", "host", "name", "description"},
+		"filter": map[string][]string{
+			"host": templateNames,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("getting templates: %s", err)
+	}
+
+	for _, zabbixTemplate := range zabbixTemplates {
+		oldTemplate := p.AddTemplate(&CustomHost{
+			State: StateOld,
+			Host: zabbix.Host{
+				HostId: zabbixTemplate.TemplateId,
+				Host:   zabbixTemplate.Host,
+				Name:   zabbixTemplate.Name,
+			},
+			Items:        map[string]*CustomItem{},
+			Applications: map[string]*CustomApplication{},
+			Triggers:     map[string]*CustomTrigger{},
+		})
+		log.Debugf("Template from Zabbix: %+v", oldTemplate)
+
+		// Templates created without the marker get it
+		if zabbixTemplate.Description != ManagedTemplateDescription {
+			oldTemplate.State = StateUpdated
+		}
+
+		err = p.FillObjectsFromZabbix(oldTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the managed templates to link to a host and to unlink from it
+// Only templates carrying the marker or in the configuration are unlinked, templates linked by hand are left alone
+func (z *CustomZabbix) GetTemplateLinks(host *CustomHost) (link []string, unlink []string) {
+
+	for name := range host.Templates {
+		if _, ok := host.LinkedTemplates[name]; !ok {
+			link = append(link, name)
+		}
+	}
+
+	for name := range host.LinkedTemplates {
+		if _, ok := host.Templates[name]; ok {
+			continue
+		}
+		_, configured := z.Templates[name]
+		_, managed := z.ManagedTemplates[name]
+		if configured || managed {
+			unlink = append(unlink, name)
+		}
+	}
+
+	sort.Strings(link)
+	sort.Strings(unlink)
+	return
+}
+
+// Get the id of the group of the managed templates, a host group before Zabbix 6.2
+func (p *Provisioner) GetTemplateGroupId() string {
+	if p.UseTemplateGroups() {
+		return p.TemplateGroups[p.Config.TemplateGroup].GroupId
+	}
+	return p.HostGroups[p.Config.TemplateGroup].GroupId
+}
+
+// Create the new managed templates in their group, and add the marker to the ones missing it
+func (p *Provisioner) ApplyTemplates() error {
+
+	templateGroups := zabbix.HostGroups{}
+	for _, templateGroup := range p.TemplateGroups {
+		if templateGroup.State == StateNew {
+			templateGroups = append(templateGroups, templateGroup.HostGroup)
+		}
+	}
+
+	if len(templateGroups) != 0 {
+		log.Debugf("Creating TemplateGroups: %+v\n", templateGroups)
+		err := p.TemplateGroupsCreate(templateGroups)
+		if err != nil {
+			return fmt.Errorf("creating template groups: %s", err)
+		}
+		CountChanges("templategroup", ActionCreate, len(templateGroups))
+
+		for _, templateGroup := range templateGroups {
+			p.TemplateGroups[templateGroup.Name].GroupId = templateGroup.GroupId
+		}
+	}
+
+	templates := Templates{}
+	for _, template := range p.Templates {
+		switch template.State {
+		case StateNew:
+			templates = append(templates, Template{
+				Host:        template.Host.Host,
+				Name:        template.Name,
+				Description: ManagedTemplateDescription,
+				Groups:      zabbix.HostGroupIds{{GroupId: p.GetTemplateGroupId()}},
+			})
+		case StateUpdated:
+			log.Debugf("Marking template %s as managed", template.Name)
+			err := p.TemplatesUpdate(Templates{{TemplateId: template.HostId, Description: ManagedTemplateDescription}})
+			if err != nil {
+				return fmt.Errorf("updating template %s: %s", template.Name, err)
+			}
+			CountChanges("template", ActionUpdate, 1)
+		}
+	}
+
+	if len(templates) == 0 {
+		return nil
+	}
+
+	log.Debugf("Creating Templates: %+v\n", templates)
+	err := p.TemplatesCreate(templates)
+	if err != nil {
+		return fmt.Errorf("creating templates: %s", err)
+	}
+	CountChanges("template", ActionCreate, len(templates))
+
+	// Make sure we update ids for the newly created templates
+	for _, newTemplate := range templates {
+		p.Templates[newTemplate.Host].HostId = newTemplate.TemplateId
+	}
+
+	return nil
+}
+
+// Unlink the managed templates hosts don't use anymore, along with the items and triggers they brought
+func (p *Provisioner) UnlinkTemplates() error {
+
+	for _, host := range p.Hosts {
+
+		_, unlink := p.GetTemplateLinks(host)
+		if len(unlink) == 0 {
+			continue
+		}
+
+		templateIds := make([]string, 0, len(unlink))
+		for _, name := range unlink {
+			templateIds = append(templateIds, host.LinkedTemplates[name])
+		}
+
+		log.Debugf("Unlinking templates %v from host %s", unlink, host.Name)
+		err := p.HostTemplatesUnlink(host.HostId, templateIds)
+		if err != nil {
+			return fmt.Errorf("unlinking templates from host %s: %s", host.Name, err)
+		}
+		CountChanges("link", ActionDelete, len(unlink))
+	}
+
+	return nil
+}
+
+// Link the managed templates to the hosts using them
+func (p *Provisioner) LinkTemplates() error {
+
+	for _, host := range p.Hosts {
+
+		link, _ := p.GetTemplateLinks(host)
+
+		if len(link) != 0 {
+			templateIds := make([]string, 0, len(link))
+			for _, name := range link {
+				templateIds = append(templateIds, p.Templates[name].HostId)
+			}
+
+			log.Debugf("Linking templates %v to host %s", link, host.Name)
+			err := p.HostTemplatesLink(host.HostId, templateIds)
+			if err != nil {
+				return fmt.Errorf("linking templates to host %s: %s", host.Name, err)
+			}
+			CountChanges("link", ActionCreate, len(link))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package provisioner
+
+import (
+	"github.com/gmauleon/zabbix-client"
+	"reflect"
+	"testing"
+)
+
+func TestGetTemplateLinks(t *testing.T) {
+
+	z := NewCustomZabbix()
+	z.Templates["prometheus-infra"] = &CustomHost{}
+	z.Templates["prometheus-apps"] = &CustomHost{}
+	z.ManagedTemplates["prometheus-old"] = struct{}{}
+
+	host := &CustomHost{
+		Templates: map[string]struct{}{"prometheus-infra": {}, "prometheus-apps": {}},
+		LinkedTemplates: map[string]string{
+			"prometheus-apps": "10",
+			"prometheus-old":  "11",
+			"Linux by agent":  "12",
+		},
+	}
+
+	// Templates linked by hand are left alone
+	link, unlink := z.GetTemplateLinks(host)
+	if !reflect.DeepEqual(link, []string{"prometheus-infra"}) {
+		t.Errorf("expected to link prometheus-infra, got %v", link)
+	}
+	if !reflect.DeepEqual(unlink, []string{"prometheus-old"}) {
+		t.Errorf("expected to unlink prometheus-old, got %v", unlink)
+	}
+}
+
+func TestApplyTemplates(t *testing.T) {
+
+	created := Templates{}
+	updated := Templates{}
+	server := newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+		"templategroup.create": func(params map[string]interface{}) interface{} {
+			return map[string][]string{"groupids": {"20"}}
+		},
+		"template.create": func(params map[string]interface{}) interface{} {
+			created = append(created, Template{
+				Host:        params["host"].(string),
+				Description: params["description"].(string),
+				Groups:      zabbix.HostGroupIds{{GroupId: params["groups"].([]interface{})[0].(map[string]interface{})["groupid"].(string)}},
+			})
+			return map[string][]string{"templateids": {"30"}}
+		},
+		"template.update": func(params map[string]interface{}) interface{} {
+			updated = append(updated, Template{TemplateId: params["templateid"].(string), Description: params["description"].(string)})
+			return map[string][]string{"templateids": {params["templateid"].(string)}}
+		},
+	})
+	defer server.Close()
+
+	p := &Provisioner{
+		Api:           zabbix.NewAPI(server.URL),
+		Config:        ProvisionerConfig{TemplateGroup: "Templates/Prometheus"},
+		CustomZabbix:  NewCustomZabbix(),
+		ZabbixVersion: &ZabbixVersion{Major: 6, Minor: 2},
+	}
+
+	// A template created before the marker existed, and a new one
+	p.AddTemplate(&CustomHost{State: StateUpdated, Host: zabbix.Host{HostId: "10", Host: "prometheus-apps", Name: "prometheus-apps"}})
+	p.GetTemplate("prometheus-infra")
+
+	err := p.ApplyTemplates()
+	if err != nil {
+		t.Fatalf("ApplyTemplates: %s", err)
+	}
+
+	expectedCreated := Templates{{Host: "prometheus-infra", Description: ManagedTemplateDescription, Groups: zabbix.HostGroupIds{{GroupId: "20"}}}}
+	if !reflect.DeepEqual(created, expectedCreated) {
+		t.Errorf("expected created templates %+v, got %+v", expectedCreated, created)
+	}
+	if p.Templates["prometheus-infra"].HostId != "30" {
+		t.Errorf("expected the id of the created template, got %q", p.Templates["prometheus-infra"].HostId)
+	}
+
+	expectedUpdated := Templates{{TemplateId: "10", Description: ManagedTemplateDescription}}
+	if !reflect.DeepEqual(updated, expectedUpdated) {
+		t.Errorf("expected the marker added to %+v, got %+v", expectedUpdated, updated)
+	}
+}
+
+func TestFillFromPrometheusSharedTemplate(t *testing.T) {
+
+	p := &Provisioner{
+		Config: ProvisionerConfig{
+			ZabbixKeyPrefix: "prometheus",
+			TemplateGroup:   "Templates/Prometheus",
+			ZabbixHosts: []HostConfig{
+				{Name: "prod", SourceSelector: []string{"prod"}, ZabbixTemplate: "prometheus"},
+				{Name: "all", MatchAll: true, ZabbixTemplate: "prometheus"},
+			},
+		},
+		RuleSources: []RuleSource{
+			&namedRuleSource{name: "prod", rules: []PrometheusRule{{Name: "NodeDown"}}},
+			&namedRuleSource{name: "staging", rules: []PrometheusRule{{Name: "DiskFull"}}},
+		},
+		CustomZabbix: NewCustomZabbix(),
+	}
+
+	err := p.FillFromPrometheus()
+	if err != nil {
+		t.Fatalf("FillFromPrometheus: %s", err)
+	}
+
+	// The template only holds the rules of the first host, DiskFull is only selected by the second one
+	template := p.Templates["prometheus"]
+	if _, ok := template.Items["prometheus.nodedown"]; !ok || len(template.Items) != 1 {
+		t.Errorf("expected the rules of prod in the template, got %+v", template.Items)
+	}
+
+	for _, name := range []string{"prod", "all"} {
+		host := p.Hosts[name]
+		if _, ok := host.Templates["prometheus"]; !ok || len(host.Items) != 0 {
+			t.Errorf("expected %s to only link the template, got templates %v and items %+v", name, host.Templates, host.Items)
+		}
+	}
+}
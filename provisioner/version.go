@@ -0,0 +1,117 @@
+package provisioner
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// Version of the Zabbix server, some features depend on it
+type ZabbixVersion struct {
+	Major int
+	Minor int
+}
+
+func ParseZabbixVersion(version string) (*ZabbixVersion, error) {
+
+	parts := strings.SplitN(strings.TrimSpace(version), ".", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid Zabbix version %q", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Zabbix version %q", version)
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Zabbix version %q", version)
+	}
+
+	return &ZabbixVersion{Major: major, Minor: minor}, nil
+}
+
+func (v *ZabbixVersion) AtLeast(major int, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+func (v *ZabbixVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Get the version of the Zabbix server through apiinfo.version, unless set in the configuration
+// It is detected once, and again after ForgetVersion
+func (p *Provisioner) DetectVersion() error {
+
+	if p.ZabbixVersion != nil {
+		return nil
+	}
+
+	version := p.Config.ZabbixApiVersion
+	if len(version) == 0 {
+
+		// apiinfo.version must be called without authentication
+		auth, bearerToken := p.Api.Auth, p.BearerToken
+		p.Api.Auth, p.BearerToken = "", ""
+		err := p.Call("apiinfo.version", func() (err error) {
+			version, err = p.Api.Version()
+			return
+		})
+		p.Api.Auth, p.BearerToken = auth, bearerToken
+
+		if err != nil {
+			return fmt.Errorf("error while getting the Zabbix version: %s", err)
+		}
+	}
+
+	zabbixVersion, err := ParseZabbixVersion(version)
+	if err != nil {
+		return err
+	}
+
+	// The authentication depends on the version, login again with the new one
+	if p.LastZabbixVersion != nil && *p.LastZabbixVersion != *zabbixVersion {
+		log.Warnf("Zabbix version changed from %s to %s", p.LastZabbixVersion, zabbixVersion)
+		p.LoggedIn = false
+	}
+
+	p.ZabbixVersion = zabbixVersion
+	p.LastZabbixVersion = zabbixVersion
+	if p.UseTags() {
+		log.Infof("Zabbix version %s, using tags instead of applications", zabbixVersion)
+	} else if p.UseTriggerTags() {
+		log.Infof("Zabbix version %s, using tags on triggers only", zabbixVersion)
+	} else {
+		log.Warnf("Zabbix version %s has no tags, zabbix_tags and labelTags are ignored", zabbixVersion)
+	}
+	return nil
+}
+
+// Detect the version again on the next cycle, as Zabbix may have been upgraded, unless it is set in the configuration
+func (p *Provisioner) ForgetVersion() {
+	if len(p.Config.ZabbixApiVersion) == 0 {
+		p.ZabbixVersion = nil
+	}
+}
+
+// Zabbix 5.4 replaced applications with tags
+func (p *Provisioner) UseTags() bool {
+	return p.ZabbixVersion != nil && p.ZabbixVersion.AtLeast(5, 4)
+}
+
+// Zabbix 6.2 moved templates from host groups to template groups
+func (p *Provisioner) UseTemplateGroups() bool {
+	return p.ZabbixVersion != nil && p.ZabbixVersion.AtLeast(6, 2)
+}
+
+// Triggers have tags since Zabbix 3.2, items only since 5.4
+func (p *Provisioner) UseTriggerTags() bool {
+	return p.ZabbixVersion != nil && p.ZabbixVersion.AtLeast(3, 2)
+}
+
+// Zabbix 6.4 takes the session or API token in an Authorization header, 7.2 removed the auth field
+func (p *Provisioner) UseBearerAuth() bool {
+	return p.ZabbixVersion != nil && p.ZabbixVersion.AtLeast(6, 4)
+}
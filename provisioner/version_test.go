@@ -0,0 +1,94 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetectVersionAfterUpgrade(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		pinned   string
+		upgraded bool
+	}{
+		{name: "detected", upgraded: true},
+		{name: "pinned", pinned: "5.2", upgraded: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version := "5.2.0"
+			server := newZabbixServer(t, map[string]func(params map[string]interface{}) interface{}{
+				"apiinfo.version": func(map[string]interface{}) interface{} { return version },
+				"user.login":      func(map[string]interface{}) interface{} { return "session" },
+			})
+			defer server.Close()
+
+			p := &Provisioner{Config: ProvisionerConfig{ZabbixApiVersion: test.pinned}}
+			p.Api = p.NewZabbixAPI(server.URL, http.DefaultTransport)
+
+			if err := p.Login(); err != nil {
+				t.Fatalf("Login: %s", err)
+			}
+			if p.UseTags() || len(p.BearerToken) != 0 {
+				t.Fatalf("expected Zabbix 5.2 without tags nor bearer auth, got %s", p.ZabbixVersion)
+			}
+
+			// Zabbix upgraded while running, then a cycle fails
+			version = "6.4.0"
+			p.ForgetVersion()
+
+			if err := p.DetectVersion(); err != nil {
+				t.Fatalf("DetectVersion: %s", err)
+			}
+			if err := p.Login(); err != nil {
+				t.Fatalf("Login: %s", err)
+			}
+
+			if bearerAuth := len(p.BearerToken) != 0; p.UseTags() != test.upgraded || bearerAuth != test.upgraded {
+				t.Errorf("expected the upgrade to be detected: %t, got version %s and bearer auth %t", test.upgraded, p.ZabbixVersion, bearerAuth)
+			}
+		})
+	}
+}
+
+func TestDetectVersionWithoutAuth(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string  `json:"method"`
+			Auth   *string `json:"auth"`
+			Id     int32   `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("can't decode request: %s", err)
+		}
+		if req.Auth != nil || len(r.Header.Get("Authorization")) != 0 {
+			t.Errorf("expected %s without authentication", req.Method)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": "7.2.0", "id": req.Id})
+	}))
+	defer server.Close()
+
+	for _, bearerAuth := range []bool{false, true} {
+		p := &Provisioner{}
+		p.Api = p.NewZabbixAPI(server.URL, http.DefaultTransport)
+		if bearerAuth {
+			p.BearerToken = "session"
+		} else {
+			p.Api.Auth = "session"
+		}
+
+		if err := p.DetectVersion(); err != nil {
+			t.Fatalf("DetectVersion: %s", err)
+		}
+
+		// The session is kept for the other calls
+		if p.Api.Auth+p.BearerToken != "session" {
+			t.Errorf("expected the session to be restored, got %q and %q", p.Api.Auth, p.BearerToken)
+		}
+	}
+}
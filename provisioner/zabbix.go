@@ -3,7 +3,6 @@ package provisioner
 import (
 	"github.com/gmauleon/zabbix-client"
 	log "github.com/sirupsen/logrus"
-	"strings"
 )
 
 type State int
@@ -22,6 +21,15 @@ var StateName = map[State]string{
 	StateOld:     "Old",
 }
 
+var PriorityName = map[zabbix.PriorityType]string{
+	zabbix.NotClassified: "not classified",
+	zabbix.Information:   "information",
+	zabbix.Warning:       "warning",
+	zabbix.Average:       "average",
+	zabbix.High:          "high",
+	zabbix.Critical:      "critical",
+}
+
 type CustomApplication struct {
 	State State
 	zabbix.Application
@@ -30,6 +38,13 @@ type CustomApplication struct {
 type CustomTrigger struct {
 	State State
 	zabbix.Trigger
+	// Trigger as it is in Zabbix, when updated
+	Current     *CustomTrigger
+	DriftPolicy string
+	// Hash recorded by DesiredState instead of the desired one, set by KeepDrift
+	KeptHash string
+	// Zabbix 3.2+
+	Tags map[Tag]struct{}
 }
 
 type CustomHostGroup struct {
@@ -41,6 +56,13 @@ type CustomItem struct {
 	State State
 	zabbix.Item
 	Applications map[string]struct{}
+	// Item as it is in Zabbix, when updated
+	Current     *CustomItem
+	DriftPolicy string
+	// Hash recorded by DesiredState instead of the desired one, set by KeepDrift
+	KeptHash string
+	// Zabbix 5.4+, replacing applications
+	Tags map[Tag]struct{}
 }
 
 type CustomHost struct {
@@ -50,20 +72,60 @@ type CustomHost struct {
 	Applications map[string]*CustomApplication
 	Items        map[string]*CustomItem
 	Triggers     map[string]*CustomTrigger
+	// Host as it is in Zabbix, when updated
+	Current *CustomHost
+	// Managed templates the host should be linked to, and templates linked to it in Zabbix by name
+	Templates       map[string]struct{}
+	LinkedTemplates map[string]string
+	// Managed discovery rules by key, with their prototypes
+	DiscoveryRules map[string]*CustomDiscoveryRule
 }
 
 type CustomZabbix struct {
 	Hosts      map[string]*CustomHost
 	HostGroups map[string]*CustomHostGroup
+	// Managed templates, holding items, applications and triggers like hosts
+	Templates map[string]*CustomHost
+	// Templates linked to the hosts that carry the managed marker, configured or not
+	ManagedTemplates map[string]struct{}
+	// Number of items, triggers and discovery rules of the managed templates to unlink from a host
+	TemplateObjects map[string]int
+	// Zabbix 6.2+, groups of the managed templates
+	TemplateGroups map[string]*CustomHostGroup
 }
 
 func NewCustomZabbix() *CustomZabbix {
 	return &CustomZabbix{
-		Hosts:      map[string]*CustomHost{},
-		HostGroups: map[string]*CustomHostGroup{},
+		Hosts:            map[string]*CustomHost{},
+		HostGroups:       map[string]*CustomHostGroup{},
+		Templates:        map[string]*CustomHost{},
+		ManagedTemplates: map[string]struct{}{},
+		TemplateObjects:  map[string]int{},
+		TemplateGroups:   map[string]*CustomHostGroup{},
 	}
 }
 
+// Templates then hosts, everything holding items, applications and triggers
+func (z *CustomZabbix) HostsAndTemplates() []*CustomHost {
+
+	hosts := make([]*CustomHost, 0, len(z.Templates)+len(z.Hosts))
+	for _, template := range z.Templates {
+		hosts = append(hosts, template)
+	}
+	for _, host := range z.Hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// Get a host or a template by name, Zabbix doesn't allow both to have the same name
+func (z *CustomZabbix) GetHostOrTemplate(name string) *CustomHost {
+	if host, ok := z.Hosts[name]; ok {
+		return host
+	}
+	return z.Templates[name]
+}
+
 func (z *CustomZabbix) AddHost(host *CustomHost) (updatedHost *CustomHost) {
 
 	updatedHost = host
@@ -78,6 +140,7 @@ func (z *CustomZabbix) AddHost(host *CustomHost) (updatedHost *CustomHost) {
 		} else {
 			if host.State == StateOld {
 				existing.HostId = host.HostId
+				existing.Current = host
 			}
 			existing.State = StateUpdated
 			updatedHost = existing
@@ -88,6 +151,21 @@ func (z *CustomZabbix) AddHost(host *CustomHost) (updatedHost *CustomHost) {
 	return updatedHost
 }
 
+// Templates are only created, a template found in Zabbix is kept as is
+func (z *CustomZabbix) AddTemplate(template *CustomHost) *CustomHost {
+
+	if existing, ok := z.Templates[template.Name]; ok {
+		if template.State == StateOld {
+			existing.HostId = template.HostId
+			existing.State = StateEqual
+		}
+		return existing
+	}
+
+	z.Templates[template.Name] = template
+	return template
+}
+
 func (host *CustomHost) AddItem(item *CustomItem) {
 
 	updatedItem := item
@@ -102,6 +180,7 @@ func (host *CustomHost) AddItem(item *CustomItem) {
 		} else {
 			if item.State == StateOld {
 				existing.ItemId = item.ItemId
+				existing.Current = item
 			}
 			existing.State = StateUpdated
 			updatedItem = existing
@@ -115,7 +194,10 @@ func (host *CustomHost) AddTrigger(trigger *CustomTrigger) {
 
 	updatedTrigger := trigger
 
-	if existing, ok := host.Triggers[trigger.Expression]; ok {
+	// Triggers are keyed by their normalized expression, an old syntax trigger matches the new syntax one
+	expression := NormalizeExpression(trigger.Expression)
+
+	if existing, ok := host.Triggers[expression]; ok {
 		if existing.Equal(trigger) {
 			if trigger.State == StateOld {
 				existing.TriggerId = trigger.TriggerId
@@ -125,13 +207,14 @@ func (host *CustomHost) AddTrigger(trigger *CustomTrigger) {
 		} else {
 			if trigger.State == StateOld {
 				existing.TriggerId = trigger.TriggerId
+				existing.Current = trigger
 			}
 			existing.State = StateUpdated
 			updatedTrigger = existing
 		}
 	}
 
-	host.Triggers[trigger.Expression] = updatedTrigger
+	host.Triggers[expression] = updatedTrigger
 }
 
 func (host *CustomHost) AddApplication(application *CustomApplication) {
@@ -154,6 +237,16 @@ func (z *CustomZabbix) AddHostGroup(hostGroup *CustomHostGroup) {
 	z.HostGroups[hostGroup.Name] = hostGroup
 }
 
+func (z *CustomZabbix) AddTemplateGroup(templateGroup *CustomHostGroup) {
+
+	if _, ok := z.TemplateGroups[templateGroup.Name]; ok {
+		if templateGroup.State == StateOld {
+			templateGroup.State = StateEqual
+		}
+	}
+	z.TemplateGroups[templateGroup.Name] = templateGroup
+}
+
 func (i *CustomHost) Equal(j *CustomHost) bool {
 	if i.Name != j.Name {
 		return false
@@ -215,11 +308,15 @@ func (i *CustomItem) Equal(j *CustomItem) bool {
 		}
 	}
 
+	if !EqualTags(i.Tags, j.Tags) {
+		return false
+	}
+
 	return true
 }
 
 func (i *CustomTrigger) Equal(j *CustomTrigger) bool {
-	if i.Expression != j.Expression {
+	if NormalizeExpression(i.Expression) != NormalizeExpression(j.Expression) {
 		return false
 	}
 
@@ -235,6 +332,10 @@ func (i *CustomTrigger) Equal(j *CustomTrigger) bool {
 		return false
 	}
 
+	if !EqualTags(i.Tags, j.Tags) {
+		return false
+	}
+
 	return true
 }
 
@@ -311,7 +412,10 @@ func (host *CustomHost) GetItemsByState() (itemsByState map[State]zabbix.Items)
 		item.HostId = host.HostId
 		item.Item.ApplicationIds = []string{}
 		for appName, _ := range item.Applications {
-			item.Item.ApplicationIds = append(item.Item.ApplicationIds, host.Applications[appName].ApplicationId)
+			// Applications of items being deleted may not be loaded
+			if application, ok := host.Applications[appName]; ok {
+				item.Item.ApplicationIds = append(item.Item.ApplicationIds, application.ApplicationId)
+			}
 		}
 		itemsByState[item.State] = append(itemsByState[item.State], item.Item)
 		log.Infof("GetItemsByState = State: %s, Key: %s, Applications: %+v", StateName[item.State], item.Key, item.Applications)
@@ -354,21 +458,3 @@ func (host *CustomHost) GetApplicationsByState() (applicationsByState map[State]
 
 	return
 }
-
-func GetZabbixPriority(severity string) zabbix.PriorityType {
-
-	switch strings.ToLower(severity) {
-	case "information":
-		return zabbix.Information
-	case "warning":
-		return zabbix.Warning
-	case "average":
-		return zabbix.Average
-	case "high":
-		return zabbix.High
-	case "critical":
-		return zabbix.Critical
-	default:
-		return zabbix.NotClassified
-	}
-}
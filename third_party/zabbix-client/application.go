@@ -0,0 +1,42 @@
+package zabbix
+
+// Applications were removed in Zabbix 5.4
+type Application struct {
+	ApplicationId string `json:"applicationid,omitempty"`
+	HostId        string `json:"hostid"`
+	Name          string `json:"name"`
+	TemplateId    string `json:"templateid,omitempty"`
+}
+
+type Applications []Application
+
+func (api *API) ApplicationsGet(params Params) (applications Applications, err error) {
+	params = withOutput(params)
+	err = api.get("application.get", params, &applications)
+	return
+}
+
+// Create applications, filling their ids
+func (api *API) ApplicationsCreate(applications Applications) error {
+
+	ids, err := api.create("application.create", applications, len(applications), "applicationids")
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		applications[i].ApplicationId = id
+	}
+
+	return nil
+}
+
+func (api *API) ApplicationsDelete(applications Applications) error {
+
+	ids := make([]string, len(applications))
+	for i, application := range applications {
+		ids[i] = application.ApplicationId
+	}
+
+	return api.delete("application.delete", ids)
+}
@@ -0,0 +1,3 @@
+module github.com/gmauleon/zabbix-client
+
+go 1.20
@@ -0,0 +1,111 @@
+package zabbix
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type AvailableType int
+
+const (
+	Unknown     AvailableType = 0
+	Available   AvailableType = 1
+	Unavailable AvailableType = 2
+)
+
+type StatusType int
+
+const (
+	Monitored   StatusType = 0
+	Unmonitored StatusType = 1
+)
+
+type InventoryType int
+
+const (
+	InventoryDisabled InventoryType = -1
+	InventoryManual   InventoryType = 0
+	InventoryAuto     InventoryType = 1
+)
+
+// Inventory fields of a host
+type HostInventory map[string]string
+
+// Zabbix returns an empty array instead of an object for hosts without inventory
+func (i *HostInventory) UnmarshalJSON(data []byte) error {
+
+	if bytes.Equal(bytes.TrimSpace(data), []byte("[]")) {
+		*i = nil
+		return nil
+	}
+
+	var inventory map[string]string
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return err
+	}
+
+	*i = inventory
+	return nil
+}
+
+type HostInterface struct {
+	DNS   string `json:"dns"`
+	IP    string `json:"ip"`
+	Main  int    `json:"main,string"`
+	Port  string `json:"port"`
+	Type  int    `json:"type,string"`
+	UseIP int    `json:"useip,string"`
+}
+
+type HostInterfaces []HostInterface
+
+type Host struct {
+	HostId        string         `json:"hostid,omitempty"`
+	Host          string         `json:"host"`
+	Available     AvailableType  `json:"available,string,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Name          string         `json:"name"`
+	Status        StatusType     `json:"status,string"`
+	InventoryMode InventoryType  `json:"inventory_mode,string"`
+	Inventory     HostInventory  `json:"inventory,omitempty"`
+	GroupIds      HostGroupIds   `json:"groups,omitempty"`
+	Interfaces    HostInterfaces `json:"interfaces,omitempty"`
+}
+
+type Hosts []Host
+
+func (api *API) HostsGet(params Params) (hosts Hosts, err error) {
+	params = withOutput(params)
+	err = api.get("host.get", params, &hosts)
+	return
+}
+
+// Create hosts, filling their ids
+func (api *API) HostsCreate(hosts Hosts) error {
+
+	ids, err := api.create("host.create", hosts, len(hosts), "hostids")
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		hosts[i].HostId = id
+	}
+
+	return nil
+}
+
+func (api *API) HostsUpdate(hosts Hosts) error {
+	_, err := api.CallWithError("host.update", hosts)
+	return err
+}
+
+func (api *API) HostsDelete(hosts Hosts) error {
+
+	ids := make([]string, len(hosts))
+	for i, host := range hosts {
+		ids[i] = host.HostId
+	}
+
+	return api.delete("host.delete", ids)
+}
@@ -0,0 +1,47 @@
+package zabbix
+
+type HostGroup struct {
+	GroupId  string `json:"groupid,omitempty"`
+	Name     string `json:"name"`
+	Internal int    `json:"internal,string,omitempty"`
+}
+
+type HostGroups []HostGroup
+
+// Reference to a host group, as used by hosts and templates
+type HostGroupId struct {
+	GroupId string `json:"groupid"`
+}
+
+type HostGroupIds []HostGroupId
+
+func (api *API) HostGroupsGet(params Params) (hostGroups HostGroups, err error) {
+	params = withOutput(params)
+	err = api.get("hostgroup.get", params, &hostGroups)
+	return
+}
+
+// Create host groups, filling their ids
+func (api *API) HostGroupsCreate(hostGroups HostGroups) error {
+
+	ids, err := api.create("hostgroup.create", hostGroups, len(hostGroups), "groupids")
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		hostGroups[i].GroupId = id
+	}
+
+	return nil
+}
+
+func (api *API) HostGroupsDelete(hostGroups HostGroups) error {
+
+	ids := make([]string, len(hostGroups))
+	for i, hostGroup := range hostGroups {
+		ids[i] = hostGroup.GroupId
+	}
+
+	return api.delete("hostgroup.delete", ids)
+}
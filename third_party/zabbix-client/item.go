@@ -0,0 +1,91 @@
+package zabbix
+
+type ItemType int
+
+const (
+	ZabbixAgent       ItemType = 0
+	SNMPv1Agent       ItemType = 1
+	ZabbixTrapper     ItemType = 2
+	SimpleCheck       ItemType = 3
+	SNMPv2Agent       ItemType = 4
+	ZabbixInternal    ItemType = 5
+	SNMPv3Agent       ItemType = 6
+	ZabbixAgentActive ItemType = 7
+	ZabbixAggregate   ItemType = 8
+	WebItem           ItemType = 9
+	ExternalCheck     ItemType = 10
+	DatabaseMonitor   ItemType = 11
+	IPMIAgent         ItemType = 12
+	SSHAgent          ItemType = 13
+	TELNETAgent       ItemType = 14
+	Calculated        ItemType = 15
+	JMXAgent          ItemType = 16
+)
+
+type ValueType int
+
+const (
+	Float     ValueType = 0
+	Character ValueType = 1
+	Log       ValueType = 2
+	Unsigned  ValueType = 3
+	Text      ValueType = 4
+)
+
+type Item struct {
+	ItemId      string    `json:"itemid,omitempty"`
+	Delay       string    `json:"delay,omitempty"`
+	HostId      string    `json:"hostid"`
+	InterfaceId string    `json:"interfaceid,omitempty"`
+	Key         string    `json:"key_"`
+	Name        string    `json:"name"`
+	Type        ItemType  `json:"type,string"`
+	ValueType   ValueType `json:"value_type,string"`
+	Description string    `json:"description"`
+	Error       string    `json:"error,omitempty"`
+
+	History      string `json:"history,omitempty"`
+	Trends       string `json:"trends,omitempty"`
+	TrapperHosts string `json:"trapper_hosts,omitempty"`
+
+	// Only sent, applications are read with application.get (Zabbix < 5.4)
+	ApplicationIds []string `json:"applications,omitempty"`
+}
+
+type Items []Item
+
+func (api *API) ItemsGet(params Params) (items Items, err error) {
+	params = withOutput(params)
+	err = api.get("item.get", params, &items)
+	return
+}
+
+// Create items, filling their ids
+func (api *API) ItemsCreate(items Items) error {
+
+	ids, err := api.create("item.create", items, len(items), "itemids")
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		items[i].ItemId = id
+	}
+
+	return nil
+}
+
+func (api *API) ItemsUpdate(items Items) error {
+	_, err := api.CallWithError("item.update", items)
+	return err
+}
+
+func (api *API) ItemsDelete(items Items) error {
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ItemId
+	}
+
+	return api.delete("item.delete", ids)
+}
@@ -0,0 +1,40 @@
+package zabbix
+
+// Get objects of a type, decoding them into result
+func (api *API) get(method string, params Params, result interface{}) error {
+
+	response, err := api.CallWithError(method, params)
+	if err != nil {
+		return err
+	}
+
+	return response.decode(result)
+}
+
+// Create objects of a type, returning the ids from the idsName field of the result
+func (api *API) create(method string, objects interface{}, count int, idsName string) ([]string, error) {
+
+	response, err := api.CallWithError(method, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.ids(idsName, count)
+}
+
+// Delete objects by ids
+func (api *API) delete(method string, ids []string) error {
+	_, err := api.CallWithError(method, ids)
+	return err
+}
+
+// Default the output of a get call to all the fields
+func withOutput(params Params) Params {
+
+	result := Params{"output": "extend"}
+	for key, value := range params {
+		result[key] = value
+	}
+
+	return result
+}
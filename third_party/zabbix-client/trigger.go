@@ -0,0 +1,59 @@
+package zabbix
+
+type PriorityType int
+
+const (
+	NotClassified PriorityType = iota
+	Information
+	Warning
+	Average
+	High
+	Critical
+)
+
+type Trigger struct {
+	TriggerId   string       `json:"triggerid,omitempty"`
+	Description string       `json:"description"`
+	Expression  string       `json:"expression"`
+	Comments    string       `json:"comments"`
+	Priority    PriorityType `json:"priority,string"`
+	Status      int          `json:"status,string"`
+}
+
+type Triggers []Trigger
+
+func (api *API) TriggersGet(params Params) (triggers Triggers, err error) {
+	params = withOutput(params)
+	err = api.get("trigger.get", params, &triggers)
+	return
+}
+
+// Create triggers, filling their ids
+func (api *API) TriggersCreate(triggers Triggers) error {
+
+	ids, err := api.create("trigger.create", triggers, len(triggers), "triggerids")
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		triggers[i].TriggerId = id
+	}
+
+	return nil
+}
+
+func (api *API) TriggersUpdate(triggers Triggers) error {
+	_, err := api.CallWithError("trigger.update", triggers)
+	return err
+}
+
+func (api *API) TriggersDelete(triggers Triggers) error {
+
+	ids := make([]string, len(triggers))
+	for i, trigger := range triggers {
+		ids[i] = trigger.TriggerId
+	}
+
+	return api.delete("trigger.delete", ids)
+}
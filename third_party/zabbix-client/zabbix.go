@@ -0,0 +1,166 @@
+// Package zabbix is a client for the Zabbix JSON-RPC API
+package zabbix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Parameters of an API call
+type Params map[string]interface{}
+
+type request struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	Auth    string      `json:"auth,omitempty"`
+	Id      int32       `json:"id"`
+}
+
+// Error returned by the API
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d (%s): %s", e.Code, e.Message, e.Data)
+}
+
+// Response of an API call
+type Response struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Error   *Error      `json:"error"`
+	Result  interface{} `json:"result"`
+	Id      int32       `json:"id"`
+}
+
+// Decode the result of a response into v
+func (r Response) decode(v interface{}) error {
+
+	data, err := json.Marshal(r.Result)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Decode the ids returned by a create call, name being the result field holding them
+func (r Response) ids(name string, expected int) ([]string, error) {
+
+	var result map[string][]string
+	if err := r.decode(&result); err != nil {
+		return nil, err
+	}
+
+	ids := result[name]
+	if len(ids) != expected {
+		return nil, fmt.Errorf("%d %s returned for %d objects", len(ids), name, expected)
+	}
+
+	return ids, nil
+}
+
+// Zabbix API client
+type API struct {
+	// Session or API token, filled by Login
+	Auth string
+
+	url string
+	c   http.Client
+	id  int32
+}
+
+// Create a client for the API at url, like https://zabbix/api_jsonrpc.php
+func NewAPI(url string) *API {
+	return &API{url: url}
+}
+
+// Use c to send the requests
+func (api *API) SetClient(c *http.Client) {
+	api.c = *c
+}
+
+// Call a method, errors returned by the API are in the response only
+func (api *API) Call(method string, params interface{}) (response Response, err error) {
+
+	body, err := json.Marshal(request{
+		Jsonrpc: "2.0",
+		Method:  method,
+		Params:  params,
+		Auth:    api.Auth,
+		Id:      atomic.AddInt32(&api.id, 1),
+	})
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest("POST", api.url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json-rpc")
+
+	res, err := api.c.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s: unexpected status %s", method, res.Status)
+		return
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&response)
+	return
+}
+
+// Call a method, errors returned by the API are returned as *Error
+func (api *API) CallWithError(method string, params interface{}) (Response, error) {
+
+	response, err := api.Call(method, params)
+	if err == nil && response.Error != nil {
+		err = response.Error
+	}
+
+	return response, err
+}
+
+// Login with a user and a password, the session is then used by all the calls
+func (api *API) Login(user, password string) (string, error) {
+
+	response, err := api.CallWithError("user.login", Params{"user": user, "password": password})
+	if err != nil {
+		return "", err
+	}
+
+	var auth string
+	if err := response.decode(&auth); err != nil {
+		return "", fmt.Errorf("user.login: %s", err)
+	}
+
+	api.Auth = auth
+	return auth, nil
+}
+
+// Get the version of the API
+func (api *API) Version() (string, error) {
+
+	response, err := api.CallWithError("apiinfo.version", Params{})
+	if err != nil {
+		return "", err
+	}
+
+	var version string
+	if err := response.decode(&version); err != nil {
+		return "", fmt.Errorf("apiinfo.version: %s", err)
+	}
+
+	return version, nil
+}
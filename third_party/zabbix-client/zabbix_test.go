@@ -0,0 +1,150 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Start a fake API answering each method with the result of handle
+func newTestAPI(t *testing.T, handle func(method string, params json.RawMessage) (interface{}, *Error)) *API {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Id     int32           `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("can't decode request: %s", err)
+		}
+
+		result, err := handle(req.Method, req.Params)
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result, "error": err, "id": req.Id})
+	}))
+	t.Cleanup(server.Close)
+
+	return NewAPI(server.URL)
+}
+
+func TestLogin(t *testing.T) {
+
+	api := newTestAPI(t, func(method string, params json.RawMessage) (interface{}, *Error) {
+		var login map[string]string
+		json.Unmarshal(params, &login)
+		if method != "user.login" || login["user"] != "user" || login["password"] != "password" {
+			t.Errorf("unexpected call to %s with %s", method, params)
+		}
+		return "session", nil
+	})
+
+	auth, err := api.Login("user", "password")
+	if err != nil {
+		t.Fatalf("Login: %s", err)
+	}
+	if auth != "session" || api.Auth != "session" {
+		t.Errorf("expected the session to be kept, got %q and %q", auth, api.Auth)
+	}
+}
+
+func TestHostsGet(t *testing.T) {
+
+	api := newTestAPI(t, func(method string, params json.RawMessage) (interface{}, *Error) {
+		return []map[string]interface{}{
+			{"hostid": "1", "host": "a", "status": "0", "inventory_mode": "0", "inventory": map[string]string{"tag": "x"}},
+			{"hostid": "2", "host": "b", "status": "1", "inventory_mode": "-1", "inventory": []string{}},
+		}, nil
+	})
+
+	hosts, err := api.HostsGet(Params{})
+	if err != nil {
+		t.Fatalf("HostsGet: %s", err)
+	}
+
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", hosts)
+	}
+	if hosts[0].Inventory["tag"] != "x" || hosts[0].Status != Monitored {
+		t.Errorf("unexpected first host %+v", hosts[0])
+	}
+	if hosts[1].Inventory != nil || hosts[1].Status != Unmonitored || hosts[1].InventoryMode != InventoryDisabled {
+		t.Errorf("unexpected second host %+v", hosts[1])
+	}
+}
+
+func TestItemsCreate(t *testing.T) {
+
+	api := newTestAPI(t, func(method string, params json.RawMessage) (interface{}, *Error) {
+		return map[string][]string{"itemids": {"10", "11"}}, nil
+	})
+
+	items := Items{{Key: "a"}, {Key: "b"}}
+	if err := api.ItemsCreate(items); err != nil {
+		t.Fatalf("ItemsCreate: %s", err)
+	}
+
+	if items[0].ItemId != "10" || items[1].ItemId != "11" {
+		t.Errorf("expected the ids to be filled, got %+v", items)
+	}
+}
+
+func TestCallWithError(t *testing.T) {
+
+	api := newTestAPI(t, func(method string, params json.RawMessage) (interface{}, *Error) {
+		return nil, &Error{Code: -32602, Message: "Invalid params.", Data: "Session terminated"}
+	})
+
+	_, err := api.CallWithError("item.get", Params{})
+	if e, ok := err.(*Error); !ok || e.Data != "Session terminated" {
+		t.Errorf("expected the API error, got %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		auth   string
+		method string
+		field  string
+	}{
+		{name: "auth field", auth: "token", method: "item.get", field: "token"},
+		{name: "no auth", method: "user.login"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Auth *string `json:"auth"`
+					Id   int32   `json:"id"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("can't decode request: %s", err)
+				}
+
+				field := ""
+				if req.Auth != nil {
+					field = *req.Auth
+					if len(field) == 0 {
+						t.Errorf("expected no auth field rather than an empty one")
+					}
+				}
+				if field != test.field {
+					t.Errorf("expected auth field %q, got %q", test.field, field)
+				}
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": "7.2.0", "id": req.Id})
+			}))
+			defer server.Close()
+
+			api := NewAPI(server.URL)
+			api.Auth = test.auth
+
+			if _, err := api.CallWithError(test.method, Params{}); err != nil {
+				t.Fatalf("%s: %s", test.method, err)
+			}
+		})
+	}
+}